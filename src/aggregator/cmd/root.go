@@ -9,6 +9,8 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/ovh/metronome/src/aggregator/consumers"
+	"github.com/ovh/metronome/src/aggregator/core"
+	"github.com/ovh/metronome/src/aggregator/routines"
 	"github.com/ovh/metronome/src/metronome/metrics"
 )
 
@@ -26,6 +28,8 @@ func init() {
 	RootCmd.Flags().StringSlice("kafka.brokers", []string{"localhost:9092"}, "kafka brokers address")
 	RootCmd.Flags().String("redis.addr", "127.0.0.1:6379", "redis address")
 	RootCmd.Flags().String("metrics.addr", "127.0.0.1:9100", "metrics address")
+	RootCmd.Flags().String("aggregator.cleanup.policy", "keep", "completed tasks cleanup policy (keep, tombstone or ttl)")
+	RootCmd.Flags().Int("aggregator.cleanup.ttl", 86400, "completed tasks retention in seconds with the ttl policy")
 
 	if err := viper.BindPFlags(RootCmd.PersistentFlags()); err != nil {
 		log.WithError(err).Error("Could not bind persistent flags")
@@ -50,12 +54,20 @@ func initConfig() {
 	viper.SetDefault("kafka.topics.tasks", "tasks")
 	viper.SetDefault("kafka.topics.jobs", "jobs")
 	viper.SetDefault("kafka.topics.states", "states")
+	viper.SetDefault("kafka.topics.calendars", "calendars")
+	viper.SetDefault("kafka.topics.audit", "audit")
 	viper.SetDefault("kafka.groups.schedulers", "schedulers")
 	viper.SetDefault("kafka.groups.aggregators", "aggregators")
 	viper.SetDefault("kafka.groups.workers", "workers")
 	viper.SetDefault("worker.poolsize", 100)
 	viper.SetDefault("token.ttl", 3600)
 	viper.SetDefault("redis.pass", "")
+	viper.SetDefault("aggregator.cleanup.interval", 60)
+	viper.SetDefault("aggregator.epsilon", 60)
+	viper.SetDefault("aggregator.deadlines.interval", 10)
+	viper.SetDefault("jobs.pull.deliveries", 10)
+	viper.SetDefault("events.replay.size", 1000)
+	viper.SetDefault("events.replay.ttl", 3600)
 
 	// Bind environment variables
 	viper.SetEnvPrefix("mtragg")
@@ -118,6 +130,27 @@ Complete documentation is available at http://ovh.github.io/metronome`,
 			log.WithError(err).Fatal("Could not start the state consumer")
 		}
 
+		cc, err := consumers.NewCalendarConsumer()
+		if err != nil {
+			log.WithError(err).Fatal("Could not start the calendar consumer")
+		}
+
+		ac, err := consumers.NewAuditConsumer()
+		if err != nil {
+			log.WithError(err).Fatal("Could not start the audit consumer")
+		}
+
+		var janitor *routines.Janitor
+		switch core.CleanupPolicy() {
+		case core.CleanupKeep, core.CleanupTombstone:
+		case core.CleanupTTL:
+			janitor = routines.NewJanitor()
+		default:
+			log.Fatalf("Unknown cleanup policy %s", core.CleanupPolicy())
+		}
+
+		deadlines := routines.NewDeadlines()
+
 		log.Info("Started")
 
 		// Trap SIGINT to trigger a shutdown.
@@ -127,6 +160,11 @@ Complete documentation is available at http://ovh.github.io/metronome`,
 		<-sigint
 
 		log.Info("Shuting down")
+		if janitor != nil {
+			janitor.Close()
+		}
+		deadlines.Close()
+
 		if err := sc.Close(); err != nil {
 			log.WithError(err).Error("Could not stop gracefully the state consumer")
 		}
@@ -134,5 +172,13 @@ Complete documentation is available at http://ovh.github.io/metronome`,
 		if err := tc.Close(); err != nil {
 			log.WithError(err).Error("Could not stop gracefully the task consumer")
 		}
+
+		if err := cc.Close(); err != nil {
+			log.WithError(err).Error("Could not stop gracefully the calendar consumer")
+		}
+
+		if err := ac.Close(); err != nil {
+			log.WithError(err).Error("Could not stop gracefully the audit consumer")
+		}
 	},
 }
@@ -0,0 +1,118 @@
+package consumers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	saramaC "github.com/bsm/sarama-cluster"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/ovh/metronome/src/metronome/kafka"
+	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/pg"
+)
+
+// AuditConsumer consumed audit events from a Kafka topic to append them to the audit database.
+type AuditConsumer struct {
+	consumer                  *saramaC.Consumer
+	doneEvents                int
+	lastCommit                time.Time
+	auditCounter              *prometheus.CounterVec
+	auditUnprocessableCounter *prometheus.CounterVec
+}
+
+// NewAuditConsumer returns a new audit consumer.
+func NewAuditConsumer() (*AuditConsumer, error) {
+	brokers := viper.GetStringSlice("kafka.brokers")
+
+	config := saramaC.NewConfig()
+	config.Config = *kafka.NewConfig()
+	config.ClientID = "metronome-aggregator"
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	consumer, err := saramaC.NewConsumer(brokers, kafka.GroupAggregators(), []string{kafka.TopicAudit()}, config)
+	if err != nil {
+		return nil, err
+	}
+
+	ac := &AuditConsumer{
+		consumer:   consumer,
+		lastCommit: time.Now(),
+	}
+
+	// metrics
+	ac.auditCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metronome",
+		Subsystem: "aggregator",
+		Name:      "audit",
+		Help:      "Number of audit events processed.",
+	},
+		[]string{"partition"})
+	prometheus.MustRegister(ac.auditCounter)
+	ac.auditUnprocessableCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metronome",
+		Subsystem: "aggregator",
+		Name:      "audit_unprocessable",
+		Help:      "Number of unprocessable audit events.",
+	},
+		[]string{"partition"})
+	prometheus.MustRegister(ac.auditUnprocessableCounter)
+
+	// Consume Kafka audit events
+	go func() {
+		for {
+			select {
+			case msg, ok := <-consumer.Messages():
+				if !ok { // shuting down
+					return
+				}
+				if err := ac.handleMsg(msg); err != nil {
+					log.WithError(err).Warn("Could not handle the audit event")
+					continue
+				}
+			}
+		}
+	}()
+
+	return ac, nil
+}
+
+// Close the consumer.
+func (ac *AuditConsumer) Close() error {
+	return ac.consumer.Close()
+}
+
+// Handle message from Kafka.
+// Append the event to the database, replayed events are ignored.
+func (ac *AuditConsumer) handleMsg(msg *sarama.ConsumerMessage) error {
+	ac.auditCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
+	var e models.AuditEvent
+	if err := e.FromKafka(msg); err != nil {
+		ac.auditUnprocessableCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
+		return err
+	}
+
+	log.Infof("AUDIT %s: %s %s", e.Action, e.ActorID, e.Target)
+
+	db := pg.DB()
+	if _, err := db.Model(&e).OnConflict("(id) DO NOTHING").Insert(); err != nil {
+		return err
+	}
+
+	ac.consumer.MarkOffset(msg, "aggregated")
+	ac.doneEvents++
+	if ac.doneEvents >= 100 || time.Now().After(ac.lastCommit.Add(time.Duration(time.Second*10))) {
+		// If more than 10 seconds since last offset commit OR more than 100 messages pending
+		if err := ac.consumer.CommitOffsets(); err != nil {
+			return err
+		}
+
+		ac.doneEvents = 0
+		ac.lastCommit = time.Now()
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package consumers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	saramaC "github.com/bsm/sarama-cluster"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/ovh/metronome/src/metronome/kafka"
+	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/pg"
+	"github.com/ovh/metronome/src/metronome/redis"
+)
+
+// CalendarConsumer consumed calendars messages from a Kafka topic to maintain the calendars database.
+type CalendarConsumer struct {
+	consumer                     *saramaC.Consumer
+	doneCalendars                int
+	lastCommit                   time.Time
+	calendarCounter              *prometheus.CounterVec
+	calendarUnprocessableCounter *prometheus.CounterVec
+}
+
+// NewCalendarConsumer returns a new calendar consumer.
+func NewCalendarConsumer() (*CalendarConsumer, error) {
+	brokers := viper.GetStringSlice("kafka.brokers")
+
+	config := saramaC.NewConfig()
+	config.Config = *kafka.NewConfig()
+	config.ClientID = "metronome-aggregator"
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	consumer, err := saramaC.NewConsumer(brokers, kafka.GroupAggregators(), []string{kafka.TopicCalendars()}, config)
+	if err != nil {
+		return nil, err
+	}
+
+	cc := &CalendarConsumer{
+		consumer:   consumer,
+		lastCommit: time.Now(),
+	}
+
+	// metrics
+	cc.calendarCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metronome",
+		Subsystem: "aggregator",
+		Name:      "calendars",
+		Help:      "Number of calendars processed.",
+	},
+		[]string{"partition"})
+	prometheus.MustRegister(cc.calendarCounter)
+	cc.calendarUnprocessableCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metronome",
+		Subsystem: "aggregator",
+		Name:      "calendars_unprocessable",
+		Help:      "Number of unprocessable calendars.",
+	},
+		[]string{"partition"})
+	prometheus.MustRegister(cc.calendarUnprocessableCounter)
+
+	// Consume Kafka Calendars
+	go func() {
+		for {
+			select {
+			case msg, ok := <-consumer.Messages():
+				if !ok { // shuting down
+					return
+				}
+				if err := cc.handleMsg(msg); err != nil {
+					log.WithError(err).Warn("Could not handle the calendar")
+					continue
+				}
+			}
+		}
+	}()
+
+	return cc, nil
+}
+
+// Close the consumer.
+func (cc *CalendarConsumer) Close() error {
+	return cc.consumer.Close()
+}
+
+// Handle message from Kafka.
+// Apply updates to the database.
+func (cc *CalendarConsumer) handleMsg(msg *sarama.ConsumerMessage) error {
+	cc.calendarCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
+	var c models.Calendar
+	if err := c.FromKafka(msg); err != nil {
+		cc.calendarUnprocessableCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
+		return err
+	}
+
+	db := pg.DB()
+
+	if c.Removed {
+		log.Infof("DELETE calendar: %s", c.GUID)
+
+		_, err := db.Model(&c).Delete()
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Infof("UPSERT calendar: %s", c.GUID)
+
+		_, err := db.Model(&c).OnConflict("(guid) DO UPDATE").
+			Set("timezone = ?timezone").
+			Set("dates = ?dates").
+			Set("windows = ?windows").
+			Insert()
+		if err != nil {
+			return err
+		}
+	}
+
+	body, err := c.ToJSON()
+	if err != nil {
+		return err
+	}
+
+	event := models.EventCalendarUpdated
+	if c.Removed {
+		event = models.EventCalendarDeleted
+	}
+	if err = redis.DB().PublishEvent(c.UserID, models.NewEvent(event, "", body)); err != nil {
+		return err
+	}
+
+	cc.consumer.MarkOffset(msg, "aggregated")
+	cc.doneCalendars++
+	if cc.doneCalendars >= 100 || time.Now().After(cc.lastCommit.Add(time.Duration(time.Second*10))) {
+		// If more than 10 seconds since last offset commit OR more than 100 messages pending
+		if err = cc.consumer.CommitOffsets(); err != nil {
+			return err
+		}
+
+		cc.doneCalendars = 0
+		cc.lastCommit = time.Now()
+	}
+
+	return nil
+}
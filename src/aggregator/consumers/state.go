@@ -1,16 +1,22 @@
 package consumers
 
 import (
+	"encoding/json"
 	"strconv"
+	"time"
 
 	"github.com/Shopify/sarama"
 	saramaC "github.com/bsm/sarama-cluster"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	pgV5 "gopkg.in/pg.v5"
 
+	acore "github.com/ovh/metronome/src/aggregator/core"
 	"github.com/ovh/metronome/src/metronome/kafka"
 	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/pg"
+	"github.com/ovh/metronome/src/metronome/quota"
 	"github.com/ovh/metronome/src/metronome/redis"
 )
 
@@ -21,6 +27,8 @@ type StateConsumer struct {
 	stateUnprocessableCounter *prometheus.CounterVec
 	stateProcessedCounter     *prometheus.CounterVec
 	statePublishErrorCounter  *prometheus.CounterVec
+	throttleCounter           prometheus.Counter
+	quota                     quota.Quota
 }
 
 // NewStateConsumer returns a new state consumer.
@@ -39,6 +47,7 @@ func NewStateConsumer() (*StateConsumer, error) {
 
 	sc := &StateConsumer{
 		consumer: consumer,
+		quota:    quota.Get(),
 	}
 
 	// metrics
@@ -74,6 +83,13 @@ func NewStateConsumer() (*StateConsumer, error) {
 	},
 		[]string{"partition"})
 	prometheus.MustRegister(sc.statePublishErrorCounter)
+	sc.throttleCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "metronome",
+		Subsystem: "aggregator",
+		Name:      "throttle",
+		Help:      "Number of dependent executions excluded by the users quota.",
+	})
+	prometheus.MustRegister(sc.throttleCounter)
 
 	go func() {
 		for {
@@ -117,11 +133,153 @@ func (sc *StateConsumer) handleMsg(msg *sarama.ConsumerMessage) error {
 		return err
 	}
 
+	// Before the tombstone, the dependents lookup the upstream task row
+	if err := sc.runDependents(s, body); err != nil {
+		return err
+	}
+
+	if s.Last && acore.CleanupPolicy() == acore.CleanupTombstone {
+		log.Infof("COMPLETE task: %s", s.TaskGUID)
+		if err := acore.Tombstone(s.TaskGUID); err != nil {
+			return err
+		}
+	} else if s.Last {
+		log.Infof("COMPLETE task: %s", s.TaskGUID)
+		_, err := pg.DB().Model(&models.Task{}).
+			Set("completed_at = ?", time.Unix(s.DoneAt, 0)).
+			Where("guid = ?", s.TaskGUID).
+			Update()
+		if err != nil {
+			return err
+		}
+	}
+
 	sc.stateProcessedCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
-	if err := redis.DB().PublishTopic(s.UserID, "state", string(body)).Err(); err != nil {
+	if err := acore.Publish(s.UserID, s.ProjectID, models.NewEvent(models.ExecutionEvent(s.State), s.TaskGUID, body)); err != nil {
 		sc.statePublishErrorCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
 		return err
 	}
 
 	return nil
 }
+
+// Run the tasks depending on the state task.
+// A dependent task run once all its upstream tasks are done and satisfy its trigger,
+// the upstream responses are forwarded to the worker to render its payload.
+func (sc *StateConsumer) runDependents(s models.State, body []byte) error {
+	if s.State == models.Excluded || s.State == models.Running || s.State == models.Skipped {
+		return nil
+	}
+
+	db := pg.DB()
+
+	var upstream models.Task
+	err := db.Model(&upstream).Column("id", "project_id").Where("guid = ?", s.TaskGUID).Select()
+	if err == pgV5.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	after, err := json.Marshal([]string{upstream.ID})
+	if err != nil {
+		return err
+	}
+
+	// Dependents are scoped to the upstream project, or user if none
+	var dependents models.Tasks
+	q := db.Model(&dependents).Where("after @> ?", string(after))
+	if len(upstream.ProjectID) > 0 {
+		q = q.Where("project_id = ?", upstream.ProjectID)
+	} else {
+		q = q.Where("user_id = ?", s.UserID).Where("project_id IS NULL")
+	}
+	err = q.Select()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range dependents {
+		key := acore.UpstreamsKey(d.GUID)
+
+		if err := redis.DB().HSet(key, upstream.ID, string(body)).Err(); err != nil {
+			return err
+		}
+
+		states, err := redis.DB().HGetAll(key).Result()
+		if err != nil {
+			return err
+		}
+		if len(states) < len(d.After) {
+			continue
+		}
+
+		if err := redis.DB().Del(key).Err(); err != nil {
+			return err
+		}
+
+		run := true
+		upstreams := make(map[string]*models.Response)
+		for _, id := range d.After {
+			var us models.State
+			if err := us.FromJSON([]byte(states[id])); err != nil {
+				return err
+			}
+			if !acore.Triggered(d.Trigger, us.State) {
+				run = false
+			}
+			upstreams[id] = us.Response
+		}
+		if !run {
+			continue
+		}
+
+		log.Infof("TRIGGER task: %s", d.GUID)
+		j := models.Job{
+			GUID:        d.GUID,
+			UserID:      d.UserID,
+			At:          time.Now().Unix(),
+			Epsilon:     viper.GetInt64("aggregator.epsilon"),
+			URN:         d.URN,
+			Payload:     d.Payload,
+			TaskID:      d.ID,
+			Upstream:    s.Response,
+			Upstreams:   upstreams,
+			Async:       d.Completion == models.CompletionAsync,
+			Deadline:    d.DeadlineSeconds(),
+			Concurrency: d.Concurrency,
+			Secrets:     d.SealedSecrets,
+			Capture:     d.CaptureResponse,
+			Template:    d.Template,
+			ProjectID:   d.ProjectID,
+			UpstreamID:  s.ID,
+		}
+		sc.throttle(&j)
+		if _, _, err := acore.GetKafka().Producer.SendMessage(j.ToKafka()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// throttle exclude a dependent job exceeding its user executions quota, as the schedulers do.
+// Excluded jobs are still dispatched, so their states are reported.
+func (sc *StateConsumer) throttle(j *models.Job) {
+	if sc.quota.Executions <= 0 {
+		return
+	}
+
+	total, err := redis.DB().Executions(j.UserID, time.Unix(j.At, 0), 1)
+	if err != nil {
+		log.WithError(err).Error("Cannot account executions quota")
+		return
+	}
+
+	if int(total) > sc.quota.Executions {
+		log.Warnf("THROTTLE job: %s %d", j.GUID, j.At)
+		j.Excluded = true
+		sc.throttleCounter.Inc()
+	}
+}
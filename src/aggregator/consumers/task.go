@@ -9,7 +9,9 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	pgV5 "gopkg.in/pg.v5"
 
+	acore "github.com/ovh/metronome/src/aggregator/core"
 	"github.com/ovh/metronome/src/metronome/kafka"
 	"github.com/ovh/metronome/src/metronome/models"
 	"github.com/ovh/metronome/src/metronome/pg"
@@ -116,27 +118,90 @@ func (tc *TaskConsumer) handleMsg(msg *sarama.ConsumerMessage) error {
 	}
 
 	db := pg.DB()
+	event := models.EventTaskDeleted
 
-	if t.Schedule == "" {
+	if t.Deleted() {
 		log.Infof("DELETE task: %s", t.GUID)
 
+		// Tombstones only hold the task GUID
+		if len(t.UserID) == 0 {
+			err := db.Model(&t).Column("user_id", "project_id").Where("guid = ?guid").Select()
+			if err != nil && err != pgV5.ErrNoRows {
+				return err
+			}
+		}
+
 		_, err := db.Model(&t).Delete()
 		if err != nil {
 			return err
 		}
+
+		if len(t.UserID) > 0 {
+			if err := redis.DB().HDel(t.UserID, t.GUID).Err(); err != nil {
+				return err
+			}
+		}
+
+		if err := redis.DB().Del(acore.UpstreamsKey(t.GUID)).Err(); err != nil {
+			return err
+		}
 	} else {
 		log.Infof("UPSERT task: %s", t.GUID)
 
-		_, err := db.Model(&t).OnConflict("(guid) DO UPDATE").
+		// A task ending in the past will never run
+		if t.NotAfter != nil && t.NotAfter.Before(time.Now()) {
+			now := time.Now()
+			t.CompletedAt = &now
+		}
+
+		exists, err := db.Model(&models.Task{}).Where("guid = ?", t.GUID).Count()
+		if err != nil {
+			return err
+		}
+		event = models.EventTaskCreated
+		if exists > 0 {
+			event = models.EventTaskUpdated
+		}
+
+		// Updates omitting the secrets keep the stored ones
+		secrets := "secrets = COALESCE(NULLIF(EXCLUDED.secrets, ''), tasks.secrets)"
+		if t.SealedSecrets == models.SecretsCleared {
+			t.SealedSecrets = ""
+			secrets = "secrets = NULL"
+		}
+
+		_, err = db.Model(&t).OnConflict("(guid) DO UPDATE").
+			Set("user_id = ?user_id").
+			Set("project_id = ?project_id").
 			Set("name = ?name").
 			Set("urn = ?urn").
 			Set("schedule = ?schedule").
 			Set("payload = ?payload").
+			Set("jitter = ?jitter").
+			Set("spread = ?spread").
+			Set("calendar = ?calendar").
+			Set("not_before = ?not_before").
+			Set("not_after = ?not_after").
+			Set("completed_at = ?completed_at").
+			Set("after = ?after").
+			Set("trigger = ?trigger").
+			Set("completion = ?completion").
+			Set("deadline = ?deadline").
+			Set("concurrency = ?concurrency").
+			Set(secrets).
+			Set("capture_response = ?capture_response").
+			Set("template = ?template").
 			Set("id = ?id").
 			Insert()
 		if err != nil {
 			return err
 		}
+
+		if t.CompletedAt != nil && acore.CleanupPolicy() == acore.CleanupTombstone {
+			if err := acore.Tombstone(t.GUID); err != nil {
+				return err
+			}
+		}
 	}
 	tc.taskProcessedCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
 
@@ -145,9 +210,11 @@ func (tc *TaskConsumer) handleMsg(msg *sarama.ConsumerMessage) error {
 		return err
 	}
 
-	if err = redis.DB().PublishTopic(t.UserID, "task", string(body)).Err(); err != nil {
-		tc.taskPublishErrorCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
-		return err
+	if len(t.UserID) > 0 {
+		if err = acore.Publish(t.UserID, t.ProjectID, models.NewEvent(event, t.GUID, body)); err != nil {
+			tc.taskPublishErrorCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
+			return err
+		}
 	}
 
 	tc.consumer.MarkOffset(msg, "aggregated")
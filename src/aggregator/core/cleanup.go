@@ -0,0 +1,28 @@
+package core
+
+import (
+	"github.com/spf13/viper"
+
+	"github.com/ovh/metronome/src/metronome/models"
+)
+
+const (
+	// CleanupKeep keep completed tasks
+	CleanupKeep = "keep"
+	// CleanupTombstone remove tasks as soon as they are completed
+	CleanupTombstone = "tombstone"
+	// CleanupTTL remove completed tasks after aggregator.cleanup.ttl seconds
+	CleanupTTL = "ttl"
+)
+
+// CleanupPolicy return the completed tasks cleanup policy.
+func CleanupPolicy() string {
+	return viper.GetString("aggregator.cleanup.policy")
+}
+
+// Tombstone remove a task from the tasks topic.
+// Schedulers and aggregators will process it as a deletion.
+func Tombstone(guid string) error {
+	_, _, err := GetKafka().Producer.SendMessage(models.TaskTombstone(guid))
+	return err
+}
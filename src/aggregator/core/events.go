@@ -0,0 +1,28 @@
+package core
+
+import (
+	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/pg"
+	"github.com/ovh/metronome/src/metronome/redis"
+)
+
+// Publish send an event to the members of a project, or to a user outside projects.
+func Publish(userID, projectID string, e models.Event) error {
+	if len(projectID) == 0 {
+		return redis.DB().PublishEvent(userID, e)
+	}
+
+	var members []struct {
+		UserID string
+	}
+	if _, err := pg.DB().Query(&members, `SELECT user_id FROM members WHERE project_id = ?`, projectID); err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		if err := redis.DB().PublishEvent(m.UserID, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package core
+
+import (
+	"github.com/ovh/metronome/src/metronome/models"
+)
+
+// UpstreamsKey return the redis key holding the upstream states of a dependent task.
+func UpstreamsKey(guid string) string {
+	return "upstreams:" + guid
+}
+
+// Triggered check if an upstream state satisfies a dependent task trigger.
+// Default to the success trigger.
+func Triggered(trigger string, state int64) bool {
+	switch trigger {
+	case models.TriggerFailure:
+		return state == models.Failed || state == models.Expired
+	case models.TriggerAlways:
+		return state == models.Success || state == models.Failed || state == models.Expired
+	default:
+		return state == models.Success
+	}
+}
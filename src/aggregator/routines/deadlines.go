@@ -0,0 +1,94 @@
+package routines
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/ovh/metronome/src/aggregator/core"
+	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/redis"
+)
+
+// Deadlines fail the async jobs not completed before their deadline,
+// and the pull jobs not pulled within their epsilon or never settled.
+type Deadlines struct {
+	ticker *time.Ticker
+	halt   chan struct{}
+}
+
+// NewDeadlines return a new deadlines watcher.
+// The watcher run every aggregator.deadlines.interval seconds.
+func NewDeadlines() *Deadlines {
+	d := &Deadlines{
+		ticker: time.NewTicker(time.Duration(viper.GetInt("aggregator.deadlines.interval")) * time.Second),
+		halt:   make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			select {
+			case <-d.ticker.C:
+				if err := d.sweep(); err != nil {
+					log.WithError(err).Warn("Could not fail overdue jobs")
+				}
+			case <-d.halt:
+				return
+			}
+		}
+	}()
+
+	return d
+}
+
+// Close the deadlines watcher.
+func (d *Deadlines) Close() {
+	d.ticker.Stop()
+	close(d.halt)
+}
+
+// sweep produce a failure state for each overdue job,
+// and an expired or failure state for each stale pull job.
+func (d *Deadlines) sweep() error {
+	now := time.Now()
+	err := redis.DB().Overdue(now, func(rj redis.RunningJob) error {
+		log.Infof("OVERDUE job: %s", rj.ID)
+		return done(rj.ID, rj.Job, rj.StartedAt, models.Failed, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	return redis.DB().Stale(now, viper.GetInt("jobs.pull.deliveries"), func(qj redis.QueuedJob) error {
+		log.Infof("EXPIRED job: %s", qj.ID)
+		return done(qj.ID, qj.Job, now.Unix(), models.Expired, now)
+	}, func(qj redis.QueuedJob) error {
+		log.Infof("EXHAUSTED job: %s", qj.ID)
+		return done(qj.ID, qj.Job, qj.PulledAt, models.Failed, now)
+	})
+}
+
+// done release the job task lock and produce the job state.
+func done(id string, j models.Job, startedAt int64, state int64, now time.Time) error {
+	if j.Locked() {
+		if err := redis.DB().Unlock(j.GUID, id); err != nil {
+			return err
+		}
+	}
+
+	s := models.State{
+		ID:        id,
+		TaskGUID:  j.GUID,
+		UserID:    j.UserID,
+		At:        j.At,
+		DoneAt:    now.Unix(),
+		Duration:  now.Sub(time.Unix(startedAt, 0)).Nanoseconds() / 1000,
+		URN:       j.URN,
+		State:     state,
+		Last:      j.Last,
+		ProjectID: j.ProjectID,
+	}
+	_, _, err := core.GetKafka().Producer.SendMessage(s.ToKafka())
+	return err
+}
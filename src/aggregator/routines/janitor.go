@@ -0,0 +1,72 @@
+package routines
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/ovh/metronome/src/aggregator/core"
+	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/pg"
+)
+
+// Janitor remove completed tasks once their TTL is elapsed.
+type Janitor struct {
+	ticker *time.Ticker
+	halt   chan struct{}
+}
+
+// NewJanitor return a new janitor.
+// The janitor run every aggregator.cleanup.interval seconds.
+func NewJanitor() *Janitor {
+	j := &Janitor{
+		ticker: time.NewTicker(time.Duration(viper.GetInt("aggregator.cleanup.interval")) * time.Second),
+		halt:   make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			select {
+			case <-j.ticker.C:
+				if err := j.sweep(); err != nil {
+					log.WithError(err).Warn("Could not cleanup completed tasks")
+				}
+			case <-j.halt:
+				return
+			}
+		}
+	}()
+
+	return j
+}
+
+// Close the janitor.
+func (j *Janitor) Close() {
+	j.ticker.Stop()
+	close(j.halt)
+}
+
+// sweep tombstone the completed tasks older than the TTL.
+func (j *Janitor) sweep() error {
+	deadline := time.Now().Add(-time.Duration(viper.GetInt("aggregator.cleanup.ttl")) * time.Second)
+
+	var tasks models.Tasks
+	err := pg.DB().Model(&tasks).
+		Column("guid").
+		Where("completed_at < ?", deadline).
+		Limit(1000).
+		Select()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tasks {
+		log.Infof("CLEANUP task: %s", t.GUID)
+		if err := core.Tombstone(t.GUID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -23,8 +23,11 @@ var initDatabaseCmd = &cobra.Command{
 		assets := []string{
 			"extensions.sql",
 			"users.sql",
+			"projects.sql",
 			"tasks.sql",
+			"calendars.sql",
 			"tokens.sql",
+			"audit.sql",
 		}
 
 		for _, asset := range assets {
@@ -12,9 +12,12 @@ import (
 	"github.com/urfave/negroni"
 
 	"github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/api/core/oauth"
 	"github.com/ovh/metronome/src/api/routers"
+	authSrv "github.com/ovh/metronome/src/api/services/auth"
 	"github.com/ovh/metronome/src/metronome/metrics"
 	"github.com/ovh/metronome/src/metronome/pg"
+	"github.com/ovh/metronome/src/metronome/secrets"
 )
 
 func init() {
@@ -31,6 +34,10 @@ func init() {
 	RootCmd.Flags().StringSlice("kafka.brokers", []string{"localhost:9092"}, "kafka brokers address")
 	RootCmd.Flags().String("redis.addr", "127.0.0.1:6379", "redis address")
 	RootCmd.Flags().String("metrics.addr", "127.0.0.1:9100", "metrics address")
+	RootCmd.Flags().String("oidc.issuer", "", "OpenID Connect issuer, local authentication only if empty")
+	RootCmd.Flags().String("oidc.audience", "", "OpenID Connect audience of the tokens")
+	RootCmd.Flags().String("oidc.jwks", "", "OpenID Connect JWKS url, discovered from the issuer if empty")
+	RootCmd.Flags().StringSlice("oidc.roles", []string{}, "OpenID Connect roles granted to the users, other issuer roles are dropped")
 
 	if err := viper.BindPFlags(RootCmd.PersistentFlags()); err != nil {
 		log.WithError(err).Error("Could not bind persitent flags")
@@ -54,11 +61,20 @@ func initConfig() {
 	viper.SetDefault("kafka.topics.tasks", "tasks")
 	viper.SetDefault("kafka.topics.jobs", "jobs")
 	viper.SetDefault("kafka.topics.states", "states")
+	viper.SetDefault("kafka.topics.calendars", "calendars")
+	viper.SetDefault("kafka.topics.audit", "audit")
 	viper.SetDefault("kafka.groups.schedulers", "schedulers")
 	viper.SetDefault("kafka.groups.aggregators", "aggregators")
 	viper.SetDefault("kafka.groups.workers", "workers")
 	viper.SetDefault("worker.poolsize", 100)
 	viper.SetDefault("token.ttl", 3600)
+	viper.SetDefault("token.refresh.ttl", 2592000)
+	viper.SetDefault("oidc.claims.name", "preferred_username")
+	viper.SetDefault("oidc.claims.roles", "roles")
+	viper.SetDefault("login.lockout.threshold", 5)
+	viper.SetDefault("login.lockout.delay", 30)
+	viper.SetDefault("login.lockout.max", 3600)
+	viper.SetDefault("jobs.pull.deliveries", 10)
 	viper.SetDefault("redis.pass", "")
 
 	// Bind environment variables
@@ -101,8 +117,15 @@ func initConfig() {
 	}
 
 	// Required
-	if !viper.IsSet("token.key") {
-		log.Panic("'token.key' is required")
+	if !viper.IsSet("token.key") && !viper.IsSet("token.keys") {
+		log.Panic("'token.key' or 'token.keys' is required")
+	}
+
+	if _, err := oauth.Keys(); err != nil {
+		log.WithError(err).Panic("Bad token keys")
+	}
+	if _, err := secrets.Keys(); err != nil && err != secrets.ErrDisabled {
+		log.WithError(err).Panic("Bad secrets keys")
 	}
 }
 
@@ -138,10 +161,13 @@ Complete documentation is available at http://ovh.github.io/metronome`,
 
 		// CORS support
 		n.Use(cors.New(cors.Options{
-			AllowedHeaders: []string{"Authorization", "Content-Type"},
-			AllowedMethods: []string{"GET", "POST", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "Last-Event-ID"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
 		}))
 
+		// Rate limit requests
+		n.Use(core.NewRateLimiter(authSrv.RequestUser))
+
 		// Load routes
 		router := routers.InitRoutes()
 		n.UseHandler(router)
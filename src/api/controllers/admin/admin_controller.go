@@ -0,0 +1,216 @@
+package adminctrl
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/api/core/io/in"
+	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/factories"
+	"github.com/ovh/metronome/src/api/models"
+	adminSrv "github.com/ovh/metronome/src/api/services/admin"
+	auditSrv "github.com/ovh/metronome/src/api/services/audit"
+	authSrv "github.com/ovh/metronome/src/api/services/auth"
+	projectsSrv "github.com/ovh/metronome/src/api/services/projects"
+	userSrv "github.com/ovh/metronome/src/api/services/user"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// Users endpoint search the users.
+// Query parameters: q (name search), limit and offset.
+func Users(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, err := intParam(query.Get("limit"), defaultLimit)
+	if err != nil || limit < 1 || limit > maxLimit {
+		out.JSON(w, http.StatusBadRequest, factories.Error(errors.New("Invalid limit")))
+		return
+	}
+
+	offset, err := intParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		out.JSON(w, http.StatusBadRequest, factories.Error(errors.New("Invalid offset")))
+		return
+	}
+
+	users, err := adminSrv.Users(query.Get("q"), limit, offset)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	out.JSON(w, http.StatusOK, users)
+}
+
+// Disable endpoint disable a user account and revoke its sessions.
+func Disable(w http.ResponseWriter, r *http.Request) {
+	setDisabled(w, r, true)
+}
+
+// Enable endpoint enable a disabled user account.
+func Enable(w http.ResponseWriter, r *http.Request) {
+	setDisabled(w, r, false)
+}
+
+func setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	userID := mux.Vars(r)["id"]
+
+	if self(r, userID) {
+		selfError(w)
+		return
+	}
+
+	found, err := adminSrv.Disable(userID, disabled)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !found {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Not found")))
+		return
+	}
+
+	action := "admin.user.enable"
+	if disabled {
+		action = "admin.user.disable"
+	}
+	auditSrv.Record(r, actor(r), action, userID, nil, nil)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Logout endpoint revoke the sessions and access tokens of a user.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+
+	found, err := adminSrv.Logout(userID)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !found {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Not found")))
+		return
+	}
+
+	auditSrv.Record(r, actor(r), "admin.user.logout", userID, nil, nil)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Password endpoint reset the password of a user.
+// The user sessions are revoked.
+func Password(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+
+	var user models.User
+	body, err := in.JSON(r, &user)
+	if err != nil {
+		out.JSON(w, http.StatusBadRequest, factories.Error(err))
+		return
+	}
+
+	result, err := core.ValidateJSON("admin", "password", string(body))
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !result.Valid {
+		out.JSON(w, http.StatusUnprocessableEntity, result.Errors)
+		return
+	}
+
+	existing, err := userSrv.Get(userID)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if existing == nil {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Not found")))
+		return
+	}
+
+	if _, err := userSrv.Edit(userID, &models.User{Password: user.Password}); err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	auditSrv.Record(r, actor(r), "admin.user.password", userID, nil, map[string]interface{}{"password": true})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Delete endpoint delete a user with its service accounts, tasks and calendars.
+func Delete(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+
+	if self(r, userID) {
+		selfError(w)
+		return
+	}
+
+	found, err := adminSrv.Delete(userID)
+	if err == projectsSrv.ErrLastAdmin {
+		var errs []core.JSONSchemaErr
+		errs = append(errs, core.JSONSchemaErr{
+			Field:       "user",
+			Type:        "admin",
+			Description: err.Error(),
+		})
+		out.JSON(w, http.StatusUnprocessableEntity, errs)
+		return
+	}
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !found {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Not found")))
+		return
+	}
+
+	auditSrv.Record(r, actor(r), "admin.user.delete", userID, nil, nil)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// self check if the request target the authenticated admin.
+func self(r *http.Request, userID string) bool {
+	return actor(r) == userID
+}
+
+// actor return the authenticated admin.
+func actor(r *http.Request) string {
+	return authSrv.UserID(core.Principal(r))
+}
+
+func selfError(w http.ResponseWriter) {
+	var errs []core.JSONSchemaErr
+	errs = append(errs, core.JSONSchemaErr{
+		Field:       "id",
+		Type:        "self",
+		Description: "an admin can not target its own account",
+	})
+
+	out.JSON(w, http.StatusUnprocessableEntity, errs)
+}
+
+func intParam(value string, def int) (int, error) {
+	if len(value) == 0 {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,59 @@
+package auditctrl
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/factories"
+	auditSrv "github.com/ovh/metronome/src/api/services/audit"
+	authSrv "github.com/ovh/metronome/src/api/services/auth"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// All endpoint return the audit events, newest first.
+// Query parameters: actor, action, target, limit and offset.
+// Admins read every event, other users their own events only.
+func All(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+	query := r.URL.Query()
+
+	limit := defaultLimit
+	if v := query.Get("limit"); len(v) > 0 {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > maxLimit {
+			out.JSON(w, http.StatusBadRequest, factories.Error(errors.New("Invalid limit")))
+			return
+		}
+		limit = parsed
+	}
+
+	offset := 0
+	if v := query.Get("offset"); len(v) > 0 {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			out.JSON(w, http.StatusBadRequest, factories.Error(errors.New("Invalid offset")))
+			return
+		}
+		offset = parsed
+	}
+
+	actorID := query.Get("actor")
+	if !authSrv.HasRole("admin", token) {
+		actorID = authSrv.UserID(token)
+	}
+
+	events, err := auditSrv.All(actorID, query.Get("action"), query.Get("target"), limit, offset)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	out.JSON(w, http.StatusOK, events)
+}
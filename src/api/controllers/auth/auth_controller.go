@@ -4,10 +4,15 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
 	"github.com/ovh/metronome/src/api/core"
 	"github.com/ovh/metronome/src/api/core/io/in"
 	"github.com/ovh/metronome/src/api/core/io/out"
 	"github.com/ovh/metronome/src/api/factories"
+	"github.com/ovh/metronome/src/api/models"
+	auditSrv "github.com/ovh/metronome/src/api/services/audit"
 	authSrv "github.com/ovh/metronome/src/api/services/auth"
 	userSrv "github.com/ovh/metronome/src/api/services/user"
 )
@@ -18,6 +23,7 @@ type tokenQuery struct {
 	Password     string `json:"password,omitempty"`
 	RefreshToken string `json:"refreshToken,omitempty"`
 	AccessToken  string `json:"accessToken,omitempty"`
+	IDToken      string `json:"idToken,omitempty"`
 }
 
 // AuthHandler endoint handle token requests.
@@ -55,7 +61,12 @@ func AuthHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		user, err := userSrv.Login(tokenQuery.Username, tokenQuery.Password)
+		user, err := userSrv.Login(tokenQuery.Username, tokenQuery.Password, core.ClientIP(r))
+		if err == userSrv.ErrLocked {
+			out.JSON(w, http.StatusTooManyRequests, factories.Error(err))
+			return
+		}
+
 		if err != nil {
 			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
 			return
@@ -72,6 +83,8 @@ func AuthHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		auditIssued(r, "auth.login", token)
+
 		out.JSON(w, http.StatusOK, token)
 
 	case "access":
@@ -92,29 +105,102 @@ func AuthHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		auditIssued(r, "auth.refresh", token)
+
+		out.JSON(w, http.StatusOK, token)
+
+	case "oidc":
+		oidcQueryResult, err := core.ValidateJSON("auth", "oidcQuery", string(body))
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
+		}
+
+		if !oidcQueryResult.Valid {
+			out.JSON(w, http.StatusUnprocessableEntity, oidcQueryResult.Errors)
+			return
+		}
+
+		user, err := authSrv.UserFromOIDC(tokenQuery.IDToken)
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
+		}
+
+		if user == nil {
+			out.JSON(w, http.StatusUnauthorized, factories.Error(errors.New("Invalid identity token")))
+			return
+		}
+
+		token, err := authSrv.BearerTokensFromUser(user)
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
+		}
+
+		auditIssued(r, "auth.login", token)
+
 		out.JSON(w, http.StatusOK, token)
 	}
 }
 
-// LogoutHandler endoint remove RefreshTokens
+// LogoutHandler endoint revoke the token session
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 
-	token, err := authSrv.GetToken(r.Header.Get("Authorization"))
+	token := core.Principal(r)
+
+	err := authSrv.Logout(token)
 	if err != nil {
 		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
 		return
 	}
 
-	if token == nil {
-		out.JSON(w, http.StatusUnauthorized, factories.Error(errors.New("Unauthorized")))
+	auditSrv.Record(r, authSrv.UserID(token), "auth.logout", authSrv.Session(token), nil, nil)
+
+	out.JSON(w, http.StatusOK, true)
+}
+
+// SessionsHandler endoint return the user active sessions.
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	sessions, err := authSrv.Sessions(token)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
 		return
 	}
 
-	err = authSrv.RevokeRefreshTokenFromAccess(token)
+	out.JSON(w, http.StatusOK, sessions)
+}
+
+// RevokeSessionHandler endoint revoke a user session.
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	found, err := authSrv.RevokeSession(authSrv.UserID(token), mux.Vars(r)["id"])
 	if err != nil {
 		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
 		return
 	}
 
-	out.JSON(w, http.StatusOK, true)
+	if !found {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Not found")))
+		return
+	}
+
+	auditSrv.Record(r, authSrv.UserID(token), "auth.session.revoke", mux.Vars(r)["id"], nil, nil)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// auditIssued record the tokens issuance of a session.
+func auditIssued(r *http.Request, action string, bearer *models.BearerToken) {
+	token, err := authSrv.GetToken(bearer.AccessToken)
+	if err != nil || token == nil {
+		log.WithError(err).Errorf("Could not audit the issued token %s", action)
+		return
+	}
+
+	session := authSrv.Session(token)
+	auditSrv.Record(r, authSrv.UserID(token), action, session, nil, map[string]interface{}{"session": session})
 }
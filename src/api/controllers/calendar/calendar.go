@@ -0,0 +1,100 @@
+package calendarctrl
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/api/core/io/in"
+	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/factories"
+	auditSrv "github.com/ovh/metronome/src/api/services/audit"
+	authSrv "github.com/ovh/metronome/src/api/services/auth"
+	calendarSrv "github.com/ovh/metronome/src/api/services/calendar"
+	calendarsSrv "github.com/ovh/metronome/src/api/services/calendars"
+	"github.com/ovh/metronome/src/metronome/models"
+)
+
+// Create endoint handle calendar creation.
+// Creating a calendar with an existing name replace it.
+func Create(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	var calendar models.Calendar
+	body, err := in.JSON(r, &calendar)
+	if err != nil {
+		out.JSON(w, http.StatusBadRequest, factories.Error(err))
+		return
+	}
+
+	result, err := core.ValidateJSON("calendar", "create", string(body))
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !result.Valid {
+		out.JSON(w, http.StatusUnprocessableEntity, result.Errors)
+		return
+	}
+
+	if len(calendar.Timezone) > 0 {
+		if _, err := time.LoadLocation(calendar.Timezone); err != nil {
+			var errs []core.JSONSchemaErr
+			errs = append(errs, core.JSONSchemaErr{
+				Field:       "timezone",
+				Type:        "unknown",
+				Description: "timezone is unknown",
+			})
+
+			out.JSON(w, http.StatusUnprocessableEntity, errs)
+			return
+		}
+	}
+
+	calendar.UserID = authSrv.UserID(token)
+	before, err := calendarsSrv.Get(calendar.UserID, calendar.Name)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	success := calendarSrv.Create(&calendar)
+	if !success {
+		out.JSON(w, http.StatusBadGateway, factories.Error(errors.New("Bad gateway")))
+		return
+	}
+
+	action := "calendar.create"
+	if before != nil {
+		action = "calendar.update"
+	}
+	auditSrv.Record(r, calendar.UserID, action, calendar.GUID, before, calendar)
+
+	out.JSON(w, http.StatusOK, calendar)
+}
+
+// Delete endoint handle calendar deletion.
+func Delete(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	userID := authSrv.UserID(token)
+	before, err := calendarsSrv.Get(userID, mux.Vars(r)["name"])
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	success := calendarSrv.Delete(mux.Vars(r)["name"], userID)
+	if !success {
+		out.JSON(w, http.StatusBadGateway, factories.Error(errors.New("Bad gateway")))
+		return
+	}
+
+	auditSrv.Record(r, userID, "calendar.delete", models.CalendarGUID(userID, mux.Vars(r)["name"]), before, nil)
+
+	w.WriteHeader(http.StatusOK)
+}
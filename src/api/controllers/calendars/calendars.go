@@ -0,0 +1,24 @@
+package calendarsctrl
+
+import (
+	"net/http"
+
+	"github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/factories"
+	authSrv "github.com/ovh/metronome/src/api/services/auth"
+	calendarsSrv "github.com/ovh/metronome/src/api/services/calendars"
+)
+
+// All endoint return the user calendars.
+func All(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	calendars, err := calendarsSrv.All(authSrv.UserID(token))
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	out.JSON(w, http.StatusOK, calendars)
+}
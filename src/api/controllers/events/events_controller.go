@@ -0,0 +1,212 @@
+package eventsctrl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/core/ws"
+	"github.com/ovh/metronome/src/api/factories"
+	authSrv "github.com/ovh/metronome/src/api/services/auth"
+	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/redis"
+)
+
+// Send a comment with this period to keep the stream open through proxies.
+const heartbeatPeriod = 30 * time.Second
+
+// resetEvent tell the client some events were missed, it should reload its state.
+const resetEvent = "reset"
+
+// Ticket endpoint issue a single use ticket authenticating a stream, for the clients unable to set headers.
+func Ticket(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	ticket, err := authSrv.IssueTicket(token)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	out.JSON(w, http.StatusOK, map[string]string{"ticket": ticket})
+}
+
+// Stream endpoint send the user events as server-sent events.
+// The stream is authenticated by the Authorization header, or by a ticket query parameter.
+// Query parameters: task, state and type, repeatable, filter the events as the websocket subscriptions.
+// A Last-Event-ID header resume the stream after this event.
+func Stream(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var token *jwt.Token
+	var err error
+	if ticket := query.Get("ticket"); len(ticket) > 0 {
+		token, err = authSrv.RedeemTicket(ticket)
+	} else {
+		token, err = authSrv.GetToken(r.Header.Get("Authorization"))
+	}
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+	if token == nil {
+		out.JSON(w, http.StatusUnauthorized, factories.Error(errors.New("Unauthorized")))
+		return
+	}
+	userID := authSrv.UserID(token)
+
+	filter := ws.Filter{
+		Tasks:  query["task"],
+		States: query["state"],
+		Types:  query["type"],
+	}
+	if err = filter.Validate(); err != nil {
+		out.JSON(w, http.StatusBadRequest, factories.Error(err))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(errors.New("Streaming unsupported")))
+		return
+	}
+
+	// subscribe before the replay to not miss the events published meanwhile
+	pubsub, err := redis.DB().Subscribe(userID)
+	if err != nil {
+		log.WithError(err).Error("Could not subscribe to redis")
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+	defer pubsub.Close()
+
+	lastID := r.Header.Get("Last-Event-ID")
+	var replay []models.Event
+	complete := true
+	if len(lastID) > 0 {
+		replay, complete, err = redis.DB().Events(userID, lastID)
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !complete {
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: {}\n\n", resetEvent); err != nil {
+			return
+		}
+	}
+	for i := range replay {
+		if filter.Match(&replay[i]) {
+			if err := write(w, &replay[i]); err != nil {
+				return
+			}
+		}
+		lastID = replay[i].ID
+	}
+	flusher.Flush()
+
+	in := make(chan string)
+	kill := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			msg, err := pubsub.ReceiveMessage()
+			if err != nil {
+				select {
+				case kill <- struct{}{}:
+				case <-done:
+				}
+				return
+			}
+
+			select {
+			case in <- msg.Payload:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(heartbeatPeriod)
+	defer heartbeat.Stop()
+
+	var expired <-chan time.Time
+	if exp := authSrv.ExpiresAt(token); !exp.IsZero() {
+		expiry := time.NewTimer(exp.Sub(time.Now()))
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+
+	for {
+		select {
+		case msg := <-in:
+			var e models.Event
+			if err := e.FromJSON([]byte(msg)); err != nil {
+				log.WithError(err).Warn("Could not decode the event")
+				continue
+			}
+			// already replayed, events published without stream have no id
+			if len(e.ID) > 0 {
+				if !redis.EventAfter(e.ID, lastID) {
+					continue
+				}
+				lastID = e.ID
+			}
+
+			if !filter.Match(&e) {
+				continue
+			}
+			if err := write(w, &e); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-expired:
+			// the client reconnect with a fresh token and resume
+			return
+
+		case <-kill:
+			return
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// write an event to the stream.
+func write(w http.ResponseWriter, e *models.Event) error {
+	body, err := e.ToJSON()
+	if err != nil {
+		return err
+	}
+
+	if len(e.ID) > 0 {
+		if _, err := fmt.Fprintf(w, "id: %s\n", e.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, body)
+	return err
+}
@@ -0,0 +1,232 @@
+package jobsctrl
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/api/core/io/in"
+	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/factories"
+	"github.com/ovh/metronome/src/api/models"
+	authSrv "github.com/ovh/metronome/src/api/services/auth"
+	jobsSrv "github.com/ovh/metronome/src/api/services/jobs"
+	mmodels "github.com/ovh/metronome/src/metronome/models"
+)
+
+type pullQuery struct {
+	Queue      string `json:"queue"`
+	Max        int    `json:"max,omitempty"`
+	Wait       int    `json:"wait,omitempty"`
+	Visibility int    `json:"visibility,omitempty"`
+}
+
+type ackQuery struct {
+	Response interface{} `json:"response,omitempty"`
+}
+
+type nackQuery struct {
+	Requeue bool `json:"requeue,omitempty"`
+}
+
+type completeQuery struct {
+	Success  bool        `json:"success"`
+	Response interface{} `json:"response,omitempty"`
+}
+
+// owner return the owner of the handled jobs, the project if selected, else the user.
+// Write a forbidden response and return false if the user is not a project editor.
+func owner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := core.Principal(r)
+
+	projectID := r.URL.Query().Get("project")
+	if len(projectID) > 0 && !authSrv.HasProjectRole(projectID, models.RoleEditor, token) {
+		out.JSON(w, http.StatusForbidden, factories.Error(errors.New("Forbidden")))
+		return "", false
+	}
+
+	return mmodels.Owner(authSrv.UserID(token), projectID), true
+}
+
+// Pull endpoint hand over jobs of a pull queue, of the project if selected.
+// Jobs must be acked or nacked within the visibility timeout, or they are redelivered.
+func Pull(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := owner(w, r)
+	if !ok {
+		return
+	}
+
+	query := pullQuery{
+		Max:        1,
+		Visibility: 30,
+	}
+	body, err := in.JSON(r, &query)
+	if err != nil {
+		out.JSON(w, http.StatusBadRequest, factories.Error(err))
+		return
+	}
+
+	result, err := core.ValidateJSON("jobs", "pull", string(body))
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !result.Valid {
+		out.JSON(w, http.StatusUnprocessableEntity, result.Errors)
+		return
+	}
+
+	jobs, err := jobsSrv.Pull(ownerID, query.Queue, query.Max, time.Duration(query.Wait)*time.Second, time.Duration(query.Visibility)*time.Second)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	out.JSON(w, http.StatusOK, jobs)
+}
+
+// Ack endpoint mark an in flight job as successful.
+func Ack(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := owner(w, r)
+	if !ok {
+		return
+	}
+
+	var query ackQuery
+	if r.ContentLength != 0 {
+		body, err := in.JSON(r, &query)
+		if err != nil {
+			out.JSON(w, http.StatusBadRequest, factories.Error(err))
+			return
+		}
+
+		result, err := core.ValidateJSON("jobs", "ack", string(body))
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
+		}
+
+		if !result.Valid {
+			out.JSON(w, http.StatusUnprocessableEntity, result.Errors)
+			return
+		}
+	}
+
+	found, err := jobsSrv.Ack(ownerID, mux.Vars(r)["id"], query.Response)
+	if err != nil {
+		out.JSON(w, http.StatusBadGateway, factories.Error(err))
+		return
+	}
+
+	if !found {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Job not in flight")))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Nack endpoint mark an in flight job as failed, or requeue it.
+func Nack(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := owner(w, r)
+	if !ok {
+		return
+	}
+
+	var query nackQuery
+	if r.ContentLength != 0 {
+		body, err := in.JSON(r, &query)
+		if err != nil {
+			out.JSON(w, http.StatusBadRequest, factories.Error(err))
+			return
+		}
+
+		result, err := core.ValidateJSON("jobs", "nack", string(body))
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
+		}
+
+		if !result.Valid {
+			out.JSON(w, http.StatusUnprocessableEntity, result.Errors)
+			return
+		}
+	}
+
+	found, err := jobsSrv.Nack(ownerID, mux.Vars(r)["id"], query.Requeue)
+	if err != nil {
+		out.JSON(w, http.StatusBadGateway, factories.Error(err))
+		return
+	}
+
+	if !found {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Job not in flight")))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Complete endpoint report the result of an async job.
+func Complete(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := owner(w, r)
+	if !ok {
+		return
+	}
+
+	var query completeQuery
+	body, err := in.JSON(r, &query)
+	if err != nil {
+		out.JSON(w, http.StatusBadRequest, factories.Error(err))
+		return
+	}
+
+	result, err := core.ValidateJSON("jobs", "complete", string(body))
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !result.Valid {
+		out.JSON(w, http.StatusUnprocessableEntity, result.Errors)
+		return
+	}
+
+	found, err := jobsSrv.Complete(ownerID, mux.Vars(r)["id"], query.Success, query.Response)
+	if err != nil {
+		out.JSON(w, http.StatusBadGateway, factories.Error(err))
+		return
+	}
+
+	if !found {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Job not running")))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Heartbeat endpoint renew the completion deadline of an async job.
+func Heartbeat(w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := owner(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := jobsSrv.Heartbeat(ownerID, mux.Vars(r)["id"])
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !found {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Job not running")))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
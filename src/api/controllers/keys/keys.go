@@ -0,0 +1,118 @@
+package keysctrl
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/api/core/io/in"
+	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/factories"
+	auditSrv "github.com/ovh/metronome/src/api/services/audit"
+	authSrv "github.com/ovh/metronome/src/api/services/auth"
+	userSrv "github.com/ovh/metronome/src/api/services/user"
+)
+
+type keyQuery struct {
+	Name   string   `json:"name"`
+	Roles  []string `json:"roles"`
+	UserID string   `json:"user_id"`
+}
+
+// Create endoint handle API key creation.
+// The key is created for the user or one of its service accounts.
+func Create(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	// A scoped key must not create an unscoped one
+	if authSrv.IsAPIKey(token) {
+		out.JSON(w, http.StatusForbidden, factories.Error(errors.New("Forbidden")))
+		return
+	}
+
+	var keyQuery keyQuery
+	body, err := in.JSON(r, &keyQuery)
+	if err != nil {
+		out.JSON(w, http.StatusBadRequest, factories.Error(err))
+		return
+	}
+
+	result, err := core.ValidateJSON("keys", "create", string(body))
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !result.Valid {
+		out.JSON(w, http.StatusUnprocessableEntity, result.Errors)
+		return
+	}
+
+	userID := authSrv.UserID(token)
+	if len(keyQuery.UserID) > 0 && keyQuery.UserID != userID {
+		owns, err := userSrv.Owns(userID, keyQuery.UserID)
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
+		}
+
+		if !owns {
+			var errs []core.JSONSchemaErr
+			errs = append(errs, core.JSONSchemaErr{
+				Field:       "user_id",
+				Type:        "unknown",
+				Description: "user_id is not a service account of the user",
+			})
+
+			out.JSON(w, http.StatusUnprocessableEntity, errs)
+			return
+		}
+		userID = keyQuery.UserID
+	}
+
+	key, err := authSrv.CreateAPIKey(userID, keyQuery.Name, keyQuery.Roles)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	auditSrv.Record(r, authSrv.UserID(token), "key.create", key.ID, nil, key)
+
+	out.JSON(w, http.StatusOK, key)
+}
+
+// All endoint return the API keys of the user and of its service accounts.
+func All(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	keys, err := authSrv.APIKeys(authSrv.UserID(token))
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	out.JSON(w, http.StatusOK, keys)
+}
+
+// Revoke endoint handle API key revocation.
+func Revoke(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	userID := authSrv.UserID(token)
+	found, err := authSrv.RevokeAPIKey(userID, mux.Vars(r)["id"])
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !found {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Not found")))
+		return
+	}
+
+	auditSrv.Record(r, userID, "key.revoke", mux.Vars(r)["id"], nil, nil)
+
+	w.WriteHeader(http.StatusOK)
+}
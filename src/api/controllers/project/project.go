@@ -0,0 +1,159 @@
+package projectctrl
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/api/core/io/in"
+	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/factories"
+	"github.com/ovh/metronome/src/api/models"
+	auditSrv "github.com/ovh/metronome/src/api/services/audit"
+	authSrv "github.com/ovh/metronome/src/api/services/auth"
+	projectsSrv "github.com/ovh/metronome/src/api/services/projects"
+)
+
+// Create endoint handle project creation.
+// The user become the project admin, granted to the tokens issued afterward.
+func Create(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	var project models.Project
+	body, err := in.JSON(r, &project)
+	if err != nil {
+		out.JSON(w, http.StatusBadRequest, factories.Error(err))
+		return
+	}
+
+	result, err := core.ValidateJSON("project", "create", string(body))
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !result.Valid {
+		out.JSON(w, http.StatusUnprocessableEntity, result.Errors)
+		return
+	}
+
+	if err := projectsSrv.Create(authSrv.UserID(token), &project); err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	auditSrv.Record(r, authSrv.UserID(token), "project.create", project.ID, nil, project)
+
+	out.JSON(w, http.StatusOK, project)
+}
+
+// Members endoint return the project members.
+func Members(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	projectID := mux.Vars(r)["id"]
+	if !authSrv.HasProjectRole(projectID, models.RoleViewer, token) {
+		out.JSON(w, http.StatusForbidden, factories.Error(errors.New("Forbidden")))
+		return
+	}
+
+	members, err := projectsSrv.Members(projectID)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	out.JSON(w, http.StatusOK, members)
+}
+
+// SetMember endoint add a member to the project or change its role.
+func SetMember(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	projectID := mux.Vars(r)["id"]
+	if !authSrv.HasProjectRole(projectID, models.RoleAdmin, token) {
+		out.JSON(w, http.StatusForbidden, factories.Error(errors.New("Forbidden")))
+		return
+	}
+
+	var member models.Member
+	body, err := in.JSON(r, &member)
+	if err != nil {
+		out.JSON(w, http.StatusBadRequest, factories.Error(err))
+		return
+	}
+
+	result, err := core.ValidateJSON("project", "member", string(body))
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !result.Valid {
+		out.JSON(w, http.StatusUnprocessableEntity, result.Errors)
+		return
+	}
+
+	member.ProjectID = projectID
+	member.UserID = mux.Vars(r)["user"]
+
+	found, err := projectsSrv.SetMember(member.ProjectID, member.UserID, member.Role)
+	if err == projectsSrv.ErrLastAdmin {
+		var errs []core.JSONSchemaErr
+		errs = append(errs, core.JSONSchemaErr{
+			Field:       "role",
+			Type:        "admin",
+			Description: err.Error(),
+		})
+
+		out.JSON(w, http.StatusUnprocessableEntity, errs)
+		return
+	}
+
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !found {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Not found")))
+		return
+	}
+
+	auditSrv.Record(r, authSrv.UserID(token), "project.member.set", projectID, nil, member)
+
+	out.JSON(w, http.StatusOK, member)
+}
+
+// RemoveMember endoint remove a member from the project.
+func RemoveMember(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	projectID := mux.Vars(r)["id"]
+	if !authSrv.HasProjectRole(projectID, models.RoleAdmin, token) {
+		out.JSON(w, http.StatusForbidden, factories.Error(errors.New("Forbidden")))
+		return
+	}
+
+	found, err := projectsSrv.RemoveMember(projectID, mux.Vars(r)["user"])
+	if err == projectsSrv.ErrLastAdmin {
+		out.JSON(w, http.StatusUnprocessableEntity, factories.Error(err))
+		return
+	}
+
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	if !found {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Not found")))
+		return
+	}
+
+	auditSrv.Record(r, authSrv.UserID(token), "project.member.remove", projectID, map[string]interface{}{"user_id": mux.Vars(r)["user"]}, nil)
+
+	w.WriteHeader(http.StatusOK)
+}
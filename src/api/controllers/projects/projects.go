@@ -0,0 +1,24 @@
+package projectsctrl
+
+import (
+	"net/http"
+
+	"github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/factories"
+	authSrv "github.com/ovh/metronome/src/api/services/auth"
+	projectsSrv "github.com/ovh/metronome/src/api/services/projects"
+)
+
+// All endoint return the user projects.
+func All(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	projects, err := projectsSrv.All(authSrv.UserID(token))
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	out.JSON(w, http.StatusOK, projects)
+}
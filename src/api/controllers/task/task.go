@@ -3,6 +3,7 @@ package taskctrl
 import (
 	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 
@@ -10,23 +11,21 @@ import (
 	"github.com/ovh/metronome/src/api/core/io/in"
 	"github.com/ovh/metronome/src/api/core/io/out"
 	"github.com/ovh/metronome/src/api/factories"
+	amodels "github.com/ovh/metronome/src/api/models"
+	auditSrv "github.com/ovh/metronome/src/api/services/audit"
 	authSrv "github.com/ovh/metronome/src/api/services/auth"
+	calendarsSrv "github.com/ovh/metronome/src/api/services/calendars"
 	taskSrv "github.com/ovh/metronome/src/api/services/task"
+	tasksSrv "github.com/ovh/metronome/src/api/services/tasks"
 	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/quota"
+	"github.com/ovh/metronome/src/metronome/secrets"
+	"github.com/ovh/metronome/src/metronome/templates"
 )
 
 // Create endoint handle task creation.
 func Create(w http.ResponseWriter, r *http.Request) {
-	token, err := authSrv.GetToken(r.Header.Get("Authorization"))
-	if err != nil {
-		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
-		return
-	}
-
-	if token == nil {
-		out.JSON(w, http.StatusUnauthorized, factories.Error(errors.New("Unauthorized")))
-		return
-	}
+	token := core.Principal(r)
 
 	var task models.Task
 	body, err := in.JSON(r, &task)
@@ -47,34 +46,283 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only template URNs may hold spaces, to render template actions
+	if !task.Template && strings.ContainsAny(task.URN, " \t\r\n") {
+		var errs []core.JSONSchemaErr
+		errs = append(errs, core.JSONSchemaErr{
+			Field:       "urn",
+			Type:        "pattern",
+			Description: "urn must not contain spaces",
+		})
+
+		out.JSON(w, http.StatusUnprocessableEntity, errs)
+		return
+	}
+
+	if task.Template {
+		if err := templates.Check(task.URN); err != nil {
+			var errs []core.JSONSchemaErr
+			errs = append(errs, core.JSONSchemaErr{
+				Field:       "urn",
+				Type:        "template",
+				Description: err.Error(),
+			})
+
+			out.JSON(w, http.StatusUnprocessableEntity, errs)
+			return
+		}
+
+		if err := templates.Validate(task.Payload); err != nil {
+			var errs []core.JSONSchemaErr
+			errs = append(errs, core.JSONSchemaErr{
+				Field:       "payload",
+				Type:        "template",
+				Description: err.Error(),
+			})
+
+			out.JSON(w, http.StatusUnprocessableEntity, errs)
+			return
+		}
+	}
+
+	if task.NotBefore != nil && task.NotAfter != nil && !task.NotAfter.After(*task.NotBefore) {
+		var errs []core.JSONSchemaErr
+		errs = append(errs, core.JSONSchemaErr{
+			Field:       "not_after",
+			Type:        "range",
+			Description: "not_after must be after not_before",
+		})
+
+		out.JSON(w, http.StatusUnprocessableEntity, errs)
+		return
+	}
+
+	if task.Scheduled() && task.JitterSeconds() > 0 && task.JitterSeconds() >= task.PeriodSeconds() {
+		var errs []core.JSONSchemaErr
+		errs = append(errs, core.JSONSchemaErr{
+			Field:       "jitter",
+			Type:        "range",
+			Description: "jitter must be shorter than the schedule period",
+		})
+
+		out.JSON(w, http.StatusUnprocessableEntity, errs)
+		return
+	}
+
 	task.UserID = authSrv.UserID(token)
+
+	if len(task.ProjectID) > 0 && !authSrv.HasProjectRole(task.ProjectID, amodels.RoleEditor, token) {
+		out.JSON(w, http.StatusForbidden, factories.Error(errors.New("Forbidden")))
+		return
+	}
+
+	if len(task.Calendar) > 0 {
+		calendar, err := calendarsSrv.Get(task.UserID, task.Calendar)
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
+		}
+
+		if calendar == nil {
+			var errs []core.JSONSchemaErr
+			errs = append(errs, core.JSONSchemaErr{
+				Field:       "calendar",
+				Type:        "unknown",
+				Description: "calendar is unknown",
+			})
+
+			out.JSON(w, http.StatusUnprocessableEntity, errs)
+			return
+		}
+	}
+
+	if len(task.After) > 0 {
+		graph, err := tasksSrv.Dependencies(task.UserID, task.ProjectID)
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
+		}
+
+		for _, id := range task.After {
+			if _, ok := graph[id]; !ok {
+				var errs []core.JSONSchemaErr
+				errs = append(errs, core.JSONSchemaErr{
+					Field:       "after",
+					Type:        "unknown",
+					Description: "after task " + id + " is unknown",
+				})
+
+				out.JSON(w, http.StatusUnprocessableEntity, errs)
+				return
+			}
+		}
+
+		if len(task.ID) > 0 && core.Reachable(graph, task.After, task.ID) {
+			var errs []core.JSONSchemaErr
+			errs = append(errs, core.JSONSchemaErr{
+				Field:       "after",
+				Type:        "cycle",
+				Description: "after create a dependency cycle",
+			})
+
+			out.JSON(w, http.StatusUnprocessableEntity, errs)
+			return
+		}
+	}
+
+	q := quota.Get()
+	if err := q.Check(&task); err != nil {
+		quotaError(w, err)
+		return
+	}
+
+	active, err := tasksSrv.Active(task.UserID)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	// An update replace the task usage
+	guid := models.TaskGUID(task.UserID, task.ProjectID, task.ID)
+	usage := models.Tasks{task}
+	for _, t := range active {
+		if t.GUID != guid {
+			usage = append(usage, t)
+		}
+	}
+
+	if err := q.CheckUsage(usage); err != nil {
+		quotaError(w, err)
+		return
+	}
+
+	// Secrets are only readable through templates
+	if len(task.Secrets) > 0 && !task.Template {
+		var errs []core.JSONSchemaErr
+		errs = append(errs, core.JSONSchemaErr{
+			Field:       "secrets",
+			Type:        "template",
+			Description: "secrets require template to be set",
+		})
+
+		out.JSON(w, http.StatusUnprocessableEntity, errs)
+		return
+	}
+
+	if len(task.Secrets) > 0 {
+		keys, err := secrets.Keys()
+		if err == secrets.ErrDisabled {
+			var errs []core.JSONSchemaErr
+			errs = append(errs, core.JSONSchemaErr{
+				Field:       "secrets",
+				Type:        "disabled",
+				Description: "secrets are not enabled",
+			})
+
+			out.JSON(w, http.StatusUnprocessableEntity, errs)
+			return
+		}
+
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
+		}
+
+		task.SealedSecrets, err = keys.Seal(task.Secrets)
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
+		}
+	}
+
+	var before *models.Task
+	if len(task.ID) > 0 {
+		before, err = tasksSrv.Get(guid)
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
+		}
+	}
+
+	// Updates omitting the secrets keep them, empty secrets clear them
+	if task.Secrets == nil && before != nil {
+		task.SealedSecrets = before.SealedSecrets
+	} else if task.Secrets != nil && len(task.Secrets) == 0 {
+		task.SealedSecrets = models.SecretsCleared
+	}
+	task.Secrets = nil
+
+	// Pull jobs are stored as rendered in the queues, secrets would be kept in clear
+	if strings.HasPrefix(task.URN, "pull://") && len(task.SealedSecrets) > 0 && task.SealedSecrets != models.SecretsCleared {
+		var errs []core.JSONSchemaErr
+		errs = append(errs, core.JSONSchemaErr{
+			Field:       "secrets",
+			Type:        "pull",
+			Description: "secrets are not supported by pull tasks",
+		})
+
+		out.JSON(w, http.StatusUnprocessableEntity, errs)
+		return
+	}
+
 	success := taskSrv.Create(&task)
 	if !success {
 		out.JSON(w, http.StatusBadGateway, factories.Error(errors.New("Bad gateway")))
 		return
 	}
 
+	action := "task.create"
+	if before != nil {
+		action = "task.update"
+	}
+	auditSrv.Record(r, task.UserID, action, task.GUID, before, task)
+
 	out.JSON(w, http.StatusOK, task)
 }
 
 // Delete endoint handle task deletion.
 func Delete(w http.ResponseWriter, r *http.Request) {
-	token, err := authSrv.GetToken(r.Header.Get("Authorization"))
-	if err != nil {
-		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+	token := core.Principal(r)
+
+	projectID := r.URL.Query().Get("project")
+	if len(projectID) > 0 && !authSrv.HasProjectRole(projectID, amodels.RoleEditor, token) {
+		out.JSON(w, http.StatusForbidden, factories.Error(errors.New("Forbidden")))
 		return
 	}
 
-	if token == nil {
-		out.JSON(w, http.StatusUnauthorized, factories.Error(errors.New("Unauthorized")))
+	userID := authSrv.UserID(token)
+	guid := models.TaskGUID(userID, projectID, mux.Vars(r)["id"])
+	before, err := tasksSrv.Get(guid)
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
 		return
 	}
 
-	success := taskSrv.Delete(mux.Vars(r)["id"], authSrv.UserID(token))
+	success := taskSrv.Delete(mux.Vars(r)["id"], userID, projectID)
 	if !success {
 		out.JSON(w, http.StatusBadGateway, factories.Error(errors.New("Bad gateway")))
 		return
 	}
 
+	auditSrv.Record(r, userID, "task.delete", guid, before, nil)
+
 	w.WriteHeader(http.StatusOK)
 }
+
+// quotaError write a quota error, exceeded quotas are unprocessable.
+func quotaError(w http.ResponseWriter, err error) {
+	exceeded, ok := err.(*quota.Exceeded)
+	if !ok {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	var errs []core.JSONSchemaErr
+	errs = append(errs, core.JSONSchemaErr{
+		Field:       exceeded.Field,
+		Type:        "quota",
+		Description: exceeded.Description,
+	})
+
+	out.JSON(w, http.StatusUnprocessableEntity, errs)
+}
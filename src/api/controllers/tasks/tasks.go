@@ -4,26 +4,25 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/ovh/metronome/src/api/core"
 	"github.com/ovh/metronome/src/api/core/io/out"
 	"github.com/ovh/metronome/src/api/factories"
+	"github.com/ovh/metronome/src/api/models"
 	authSrv "github.com/ovh/metronome/src/api/services/auth"
 	tasksSrv "github.com/ovh/metronome/src/api/services/tasks"
 )
 
-// All endoint return the user tasks.
+// All endoint return the user tasks, or the project tasks if selected.
 func All(w http.ResponseWriter, r *http.Request) {
-	token, err := authSrv.GetToken(r.Header.Get("Authorization"))
-	if err != nil {
-		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
-		return
-	}
+	token := core.Principal(r)
 
-	if token == nil {
-		out.JSON(w, http.StatusUnauthorized, factories.Error(errors.New("Unauthorized")))
+	projectID := r.URL.Query().Get("project")
+	if len(projectID) > 0 && !authSrv.HasProjectRole(projectID, models.RoleViewer, token) {
+		out.JSON(w, http.StatusForbidden, factories.Error(errors.New("Forbidden")))
 		return
 	}
 
-	tasks, err := tasksSrv.All(authSrv.UserID(token))
+	tasks, err := tasksSrv.All(authSrv.UserID(token), projectID)
 	if err != nil {
 		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
 		return
@@ -9,6 +9,7 @@ import (
 	"github.com/ovh/metronome/src/api/core/io/out"
 	"github.com/ovh/metronome/src/api/factories"
 	"github.com/ovh/metronome/src/api/models"
+	auditSrv "github.com/ovh/metronome/src/api/services/audit"
 	authSrv "github.com/ovh/metronome/src/api/services/auth"
 	userSrv "github.com/ovh/metronome/src/api/services/user"
 )
@@ -52,21 +53,14 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	auditSrv.Record(r, user.ID, "user.create", user.ID, nil, user)
+
 	out.JSON(w, http.StatusOK, user)
 }
 
 // Edit endoint handle user edit.
 func Edit(w http.ResponseWriter, r *http.Request) {
-	token, err := authSrv.GetToken(r.Header.Get("Authorization"))
-	if err != nil {
-		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
-		return
-	}
-
-	if token == nil {
-		out.JSON(w, http.StatusUnauthorized, factories.Error(errors.New("Unauthorized")))
-		return
-	}
+	token := core.Principal(r)
 
 	var user models.User
 
@@ -87,6 +81,12 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The password is hashed by the edition
+	changes := make(map[string]interface{})
+	if len(user.Password) > 0 {
+		changes["password"] = true
+	}
+
 	duplicated, err := userSrv.Edit(authSrv.UserID(token), &user)
 	if err != nil {
 		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
@@ -105,32 +105,85 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	auditSrv.Record(r, user.ID, "user.edit", user.ID, nil, changes)
+
 	w.WriteHeader(http.StatusOK)
 }
 
 // Current endoint return the user bind to the token.
 func Current(w http.ResponseWriter, r *http.Request) {
-	token, err := authSrv.GetToken(r.Header.Get("Authorization"))
+	token := core.Principal(r)
+
+	user, err := userSrv.Get(authSrv.UserID(token))
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if user == nil {
+		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Not found")))
+		return
+	}
+
+	out.JSON(w, http.StatusOK, user)
+}
+
+// CreateServiceAccount endpoint handle the service account creation.
+// Service accounts are owned by the user and authenticate with API keys.
+func CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	var user models.User
+
+	body, err := in.JSON(r, &user)
+	if err != nil {
+		out.JSON(w, http.StatusBadRequest, factories.Error(err))
+		return
+	}
+
+	result, err := core.ValidateJSON("user", "serviceAccount", string(body))
 	if err != nil {
 		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
 		return
 	}
 
-	if token == nil {
-		out.JSON(w, http.StatusUnauthorized, factories.Error(errors.New("Unauthorized")))
+	if !result.Valid {
+		out.JSON(w, http.StatusUnprocessableEntity, result.Errors)
 		return
 	}
 
-	user, err := userSrv.Get(authSrv.UserID(token))
+	duplicated, err := userSrv.CreateServiceAccount(authSrv.UserID(token), &user)
 	if err != nil {
-		out.JSON(w, http.StatusInternalServerError, err)
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
 		return
 	}
 
-	if user == nil {
-		out.JSON(w, http.StatusNotFound, factories.Error(errors.New("Not found")))
+	if duplicated {
+		var errs []core.JSONSchemaErr
+		errs = append(errs, core.JSONSchemaErr{
+			Field:       "name",
+			Type:        "duplicated",
+			Description: "name is duplicated",
+		})
+
+		out.JSON(w, http.StatusUnprocessableEntity, errs)
 		return
 	}
 
+	auditSrv.Record(r, authSrv.UserID(token), "user.create", user.ID, nil, user)
+
 	out.JSON(w, http.StatusOK, user)
 }
+
+// ServiceAccounts endoint return the service accounts of the user.
+func ServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	token := core.Principal(r)
+
+	users, err := userSrv.ServiceAccounts(authSrv.UserID(token))
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	out.JSON(w, http.StatusOK, users)
+}
@@ -0,0 +1,28 @@
+package wellknownctrl
+
+import (
+	"net/http"
+
+	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/core/oauth"
+	"github.com/ovh/metronome/src/api/factories"
+)
+
+// JWKS endoint return the public keys verifying the metronome tokens.
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	keys, err := oauth.Keys()
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	jwks, err := keys.JWKS()
+	if err != nil {
+		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		return
+	}
+
+	out.JSON(w, http.StatusOK, map[string]interface{}{
+		"keys": jwks,
+	})
+}
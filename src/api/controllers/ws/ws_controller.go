@@ -1,90 +1,247 @@
 package wsctrl
 
 import (
-	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/websocket"
-	"github.com/ovh/metronome/src/api/core/ws"
-	"github.com/ovh/metronome/src/api/factories"
 	log "github.com/sirupsen/logrus"
+	redisV5 "gopkg.in/redis.v5"
 
-	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/core/ws"
 	authSrv "github.com/ovh/metronome/src/api/services/auth"
+	"github.com/ovh/metronome/src/metronome/models"
 	"github.com/ovh/metronome/src/metronome/redis"
 )
 
+// Time allowed to the client to authenticate the socket.
+const authWait = 10 * time.Second
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
+// socket is the state of a websocket connection.
+type socket struct {
+	client *ws.Client
+	token  *jwt.Token
+	subs   ws.Subscriptions
+	// expiry fire when the token expires, nil if it never does
+	expiry *time.Timer
+}
+
 // Join handle ws connections.
+// The client first send an auth frame, then subscribe to events.
 func Join(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.WithError(err).Error("Could not upgrade the http request to websocket")
-		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
 		return
 	}
 	client := ws.NewClient(conn)
 	defer client.Close()
 
-	// wait for auth token
-	msg, ok := <-client.Messages()
-	if !ok {
-		return
+	s := &socket{
+		client: client,
+		subs:   ws.Subscriptions{},
 	}
+	defer s.stopExpiry()
 
-	token, err := authSrv.GetToken(msg)
-	if err != nil {
-		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
-		return
-	}
+	// wait for auth frame
+	select {
+	case msg, ok := <-client.Messages():
+		if !ok {
+			return
+		}
+		f, err := ws.ParseFrame(msg)
+		if err != nil || f.Type != ws.FrameAuth {
+			send(client.Error("", ws.ErrUnauthorized, "Expected an auth frame"))
+			return
+		}
+		if !s.auth(f) {
+			return
+		}
 
-	if token == nil {
-		out.JSON(w, http.StatusUnauthorized, factories.Error(errors.New("Unauthorized")))
+	case <-time.After(authWait):
+		send(client.Error("", ws.ErrUnauthorized, "Authentication timeout"))
 		return
 	}
 
-	pubsub, err := redis.DB().Subscribe(authSrv.UserID(token))
+	pubsub, err := redis.DB().Subscribe(authSrv.UserID(s.token))
 	if err != nil {
 		log.WithError(err).Error("Could not subscribe to redis")
-		out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+		send(client.Error("", ws.ErrUnavailable, "Could not subscribe to events"))
 		return
 	}
 	defer pubsub.Close()
 
 	in := make(chan string)
 	kill := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
 
-	go func() {
-		for {
-			msg, err := pubsub.ReceiveMessage()
-			if err != nil {
-				kill <- struct{}{}
-				return
-			}
-
-			in <- msg.Payload
-		}
-	}()
+	go receive(pubsub, in, kill, done)
 
 	for {
 		select {
-		case _, ok := <-client.Messages():
+		case msg, ok := <-client.Messages():
 			if !ok { // shuting down
-				w.WriteHeader(http.StatusOK)
 				return
 			}
+			s.handle(msg)
 
 		case msg := <-in:
-			client.Send(msg)
+			s.forward(msg)
+
+		case <-s.expired():
+			send(client.Error("", ws.ErrExpired, "Token expired"))
+			return
 
 		case <-kill:
-			out.JSON(w, http.StatusBadGateway, factories.Error(errors.New("Bad gateway")))
+			send(client.Error("", ws.ErrUnavailable, "Bad gateway"))
+			return
+		}
+	}
+}
+
+// receive forward the pub/sub messages until done.
+func receive(pubsub *redisV5.PubSub, in chan<- string, kill chan<- struct{}, done <-chan struct{}) {
+	for {
+		msg, err := pubsub.ReceiveMessage()
+		if err != nil {
+			select {
+			case kill <- struct{}{}:
+			case <-done:
+			}
+			return
+		}
+
+		select {
+		case in <- msg.Payload:
+		case <-done:
+			return
+		}
+	}
+}
+
+// handle a client frame.
+func (s *socket) handle(msg string) {
+	f, err := ws.ParseFrame(msg)
+	if err != nil {
+		send(s.client.Error("", ws.ErrBadRequest, "Invalid frame"))
+		return
+	}
+
+	switch f.Type {
+	case ws.FrameAuth:
+		s.auth(f)
+
+	case ws.FrameSubscribe:
+		s.subscribe(f)
+
+	case ws.FrameUnsubscribe:
+		if _, ok := s.subs[f.Subscription]; !ok {
+			send(s.client.Error(f.ID, ws.ErrNotFound, "Unknown subscription"))
 			return
 		}
+		delete(s.subs, f.Subscription)
+		send(s.client.Ack(f.ID, f.Subscription))
+
+	default:
+		send(s.client.Error(f.ID, ws.ErrBadRequest, fmt.Sprintf("Unknown frame type %q", f.Type)))
+	}
+}
+
+// auth set the socket token, return false if the token is rejected.
+// A refreshed token must belong to the socket user.
+func (s *socket) auth(f *ws.Frame) bool {
+	token, err := authSrv.GetToken(f.Token)
+	if err != nil || token == nil {
+		send(s.client.Error(f.ID, ws.ErrUnauthorized, "Unauthorized"))
+		return false
+	}
+
+	if s.token != nil && authSrv.UserID(token) != authSrv.UserID(s.token) {
+		send(s.client.Error(f.ID, ws.ErrUnauthorized, "Token of another user"))
+		return false
+	}
+
+	s.token = token
+	s.stopExpiry()
+	if exp := authSrv.ExpiresAt(token); !exp.IsZero() {
+		s.expiry = time.NewTimer(exp.Sub(time.Now()))
+	}
+
+	send(s.client.Ack(f.ID, ""))
+	return true
+}
+
+// subscribe add a subscription, named after the frame id.
+func (s *socket) subscribe(f *ws.Frame) {
+	if len(f.ID) == 0 {
+		send(s.client.Error(f.ID, ws.ErrBadRequest, "Subscribe frames require an id"))
+		return
+	}
+
+	filter := ws.Filter{}
+	if f.Filter != nil {
+		filter = *f.Filter
+	}
+	if err := filter.Validate(); err != nil {
+		send(s.client.Error(f.ID, ws.ErrBadRequest, err.Error()))
+		return
+	}
+
+	if _, ok := s.subs[f.ID]; !ok && len(s.subs) >= ws.MaxSubscriptions {
+		send(s.client.Error(f.ID, ws.ErrBadRequest, "Too many subscriptions"))
+		return
+	}
+
+	s.subs[f.ID] = filter
+	send(s.client.Ack(f.ID, f.ID))
+}
+
+// forward an event to its matching subscriptions.
+func (s *socket) forward(msg string) {
+	var e models.Event
+	if err := e.FromJSON([]byte(msg)); err != nil {
+		log.WithError(err).Warn("Could not decode the event")
+		return
+	}
+
+	ids := s.subs.Match(&e)
+	if len(ids) == 0 {
+		return
+	}
+
+	send(s.client.SendFrame(&ws.Frame{
+		Type:          ws.FrameEvent,
+		Subscriptions: ids,
+		Event:         &e,
+	}))
+}
+
+// expired return the token expiry channel, nil if the token never expires.
+func (s *socket) expired() <-chan time.Time {
+	if s.expiry == nil {
+		return nil
+	}
+	return s.expiry.C
+}
+
+func (s *socket) stopExpiry() {
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+}
+
+func send(err error) {
+	if err != nil {
+		log.WithError(err).Error("Could not send the websocket frame")
 	}
 }
@@ -16,9 +16,14 @@ var (
 func Assets(namespace string) (*packr.Box, error) {
 	boxOnce.Do(func() {
 		boxes = map[string]packr.Box{
-			"auth": packr.NewBox("../controllers/auth/schema"),
-			"task": packr.NewBox("../controllers/task/schema"),
-			"user": packr.NewBox("../controllers/user/schema"),
+			"admin":    packr.NewBox("../controllers/admin/schema"),
+			"auth":     packr.NewBox("../controllers/auth/schema"),
+			"calendar": packr.NewBox("../controllers/calendar/schema"),
+			"jobs":     packr.NewBox("../controllers/jobs/schema"),
+			"keys":     packr.NewBox("../controllers/keys/schema"),
+			"project":  packr.NewBox("../controllers/project/schema"),
+			"task":     packr.NewBox("../controllers/task/schema"),
+			"user":     packr.NewBox("../controllers/user/schema"),
 		}
 	})
 
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ClientIP return the IP of a request client.
+// Behind trusted proxies, api.http.ipheader, formerly ratelimit.ip.header, name the header holding the client IP,
+// as a list appended by each proxy. The client IP is taken api.http.iphops entries
+// from the right, the entries left of it may be forged by the client.
+func ClientIP(r *http.Request) string {
+	header := viper.GetString("api.http.ipheader")
+	if len(header) == 0 {
+		header = viper.GetString("ratelimit.ip.header")
+	}
+	if len(header) > 0 {
+		if forwarded := r.Header.Get(header); len(forwarded) > 0 {
+			ips := strings.Split(forwarded, ",")
+			hops := viper.GetInt("api.http.iphops")
+			if hops < 1 {
+				hops = 1
+			}
+			if hops > len(ips) {
+				hops = len(ips)
+			}
+			return strings.TrimSpace(ips[len(ips)-hops])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,45 @@
+package core_test
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+
+	"github.com/ovh/metronome/src/api/core"
+)
+
+var _ = Describe("ClientIP", func() {
+	AfterEach(func() {
+		viper.Set("api.http.ipheader", "")
+		viper.Set("ratelimit.ip.header", "")
+		viper.Set("api.http.iphops", 0)
+	})
+
+	DescribeTable("Header",
+		func(header, legacyHeader string, hops int, forwarded string, ip string) {
+			viper.Set("api.http.ipheader", header)
+			viper.Set("ratelimit.ip.header", legacyHeader)
+			viper.Set("api.http.iphops", hops)
+
+			r, err := http.NewRequest("GET", "/", nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			r.RemoteAddr = "10.0.0.1:4242"
+			if len(forwarded) > 0 {
+				r.Header.Set("X-Forwarded-For", forwarded)
+			}
+
+			Ω(core.ClientIP(r)).Should(Equal(ip))
+		},
+		Entry("no header configured", "", "", 0, "1.1.1.1", "10.0.0.1"),
+		Entry("header missing", "X-Forwarded-For", "", 0, "", "10.0.0.1"),
+		Entry("single entry", "X-Forwarded-For", "", 0, "1.1.1.1", "1.1.1.1"),
+		Entry("forged left entries", "X-Forwarded-For", "", 1, "6.6.6.6, 7.7.7.7, 1.1.1.1", "1.1.1.1"),
+		Entry("two trusted hops", "X-Forwarded-For", "", 2, "6.6.6.6, 1.1.1.1, 2.2.2.2", "1.1.1.1"),
+		Entry("hops larger than the list", "X-Forwarded-For", "", 5, "1.1.1.1, 2.2.2.2", "1.1.1.1"),
+		Entry("former header key", "", "X-Forwarded-For", 0, "6.6.6.6, 1.1.1.1", "1.1.1.1"),
+		Entry("header key over the former one", "X-Real-IP", "X-Forwarded-For", 0, "1.1.1.1", "10.0.0.1"),
+	)
+})
@@ -0,0 +1,25 @@
+package core
+
+// Reachable check if a node can be reached from the given nodes of a dependency graph.
+// The graph map a node to the nodes it depends on.
+func Reachable(graph map[string][]string, from []string, to string) bool {
+	visited := make(map[string]bool)
+	stack := append([]string{}, from...)
+
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if n == to {
+			return true
+		}
+		if visited[n] {
+			continue
+		}
+		visited[n] = true
+
+		stack = append(stack, graph[n]...)
+	}
+
+	return false
+}
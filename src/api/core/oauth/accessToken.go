@@ -1,42 +1,47 @@
 package oauth
 
 import (
-	"encoding/base64"
-	"encoding/hex"
 	"errors"
-	"fmt"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	uuid "github.com/satori/go.uuid"
 	"github.com/spf13/viper"
 )
 
 // AuthClaims add roles to the jwt claims.
 type AuthClaims struct {
-	Roles        []string `json:"roles"`
-	RefreshToken string   `json:"refreshToken"`
+	Roles []string `json:"roles"`
+	// Session is the refresh token family the token was issued for
+	Session string `json:"sid,omitempty"`
+	// APIKey is the ID of the API key authenticating the token, if any
+	APIKey string `json:"-"`
+	// IssuedAtMs is the issue time in milliseconds, compared with the user revocations
+	IssuedAtMs int64 `json:"iat_ms,omitempty"`
 	jwt.StandardClaims
 }
 
-// GenerateAccessToken return a new token.
-func GenerateAccessToken(userID string, roles []string, refreshToken string) (string, error) {
+// GenerateAccessToken return a new token of a session.
+func GenerateAccessToken(userID string, roles []string, session string) (string, error) {
+	now := time.Now()
 	claims := AuthClaims{
-		roles,
-		refreshToken,
-		jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Second * time.Duration(viper.GetInt("token.ttl"))).Unix(),
-			IssuedAt:  time.Now().Unix(),
+		Roles:      roles,
+		Session:    session,
+		IssuedAtMs: now.UnixNano() / int64(time.Millisecond),
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewV4().String(),
+			ExpiresAt: now.Add(time.Second * time.Duration(viper.GetInt("token.ttl"))).Unix(),
+			IssuedAt:  now.Unix(),
 			Subject:   userID,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-	key, err := hex.DecodeString(viper.GetString("token.key"))
+	keys, err := Keys()
 	if err != nil {
 		return "", err
 	}
 
-	tokenString, err := token.SignedString(key)
+	tokenString, err := keys.Sign(claims)
 	if err != nil {
 		return "", err
 	}
@@ -47,18 +52,12 @@ func GenerateAccessToken(userID string, roles []string, refreshToken string) (st
 // GetToken return a token from a token string.
 // Return nil if the token string is invalid or if the token as expired.
 func GetToken(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &AuthClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-
-		key, err := hex.DecodeString(viper.GetString("token.key"))
-		if err != nil {
-			return nil, err
-		}
-		return key, nil
-	})
+	keys, err := Keys()
+	if err != nil {
+		return nil, err
+	}
 
+	token, err := keys.Parse(tokenString, &AuthClaims{})
 	if err != nil {
 		return nil, err
 	}
@@ -76,18 +75,45 @@ func UserID(token *jwt.Token) string {
 	return claims.Subject
 }
 
+// ID return the id of a token, empty if none.
+func ID(token *jwt.Token) string {
+	claims := token.Claims.(*AuthClaims)
+	return claims.Id
+}
+
 // Roles return the roles from a token.
 func Roles(token *jwt.Token) []string {
 	claims := token.Claims.(*AuthClaims)
 	return claims.Roles
 }
 
-// RefreshToken return the refreshToken
-func RefreshToken(token *jwt.Token) ([]byte, error) {
+// APIKey return the ID of the API key authenticating the token, empty if none.
+func APIKey(token *jwt.Token) string {
 	claims := token.Claims.(*AuthClaims)
-	refreshToken, err := base64.StdEncoding.DecodeString(claims.RefreshToken)
-	if err != nil {
-		return nil, err
+	return claims.APIKey
+}
+
+// Session return the session of a token, empty if none.
+func Session(token *jwt.Token) string {
+	claims := token.Claims.(*AuthClaims)
+	return claims.Session
+}
+
+// IssuedAt return the issue time of a token in milliseconds.
+// Tokens issued without the milliseconds claim are considered issued at the start of their second.
+func IssuedAt(token *jwt.Token) int64 {
+	claims := token.Claims.(*AuthClaims)
+	if claims.IssuedAtMs > 0 {
+		return claims.IssuedAtMs
+	}
+	return claims.IssuedAt * 1000
+}
+
+// ExpiresAt return the expiration time of a token, zero if it never expires.
+func ExpiresAt(token *jwt.Token) time.Time {
+	claims := token.Claims.(*AuthClaims)
+	if claims.ExpiresAt == 0 {
+		return time.Time{}
 	}
-	return refreshToken, nil
+	return time.Unix(claims.ExpiresAt, 0)
 }
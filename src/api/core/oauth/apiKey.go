@@ -0,0 +1,65 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/ovh/metronome/src/api/models"
+	"github.com/ovh/metronome/src/metronome/core"
+)
+
+// apiKeyPrefix distinguish API keys from access tokens.
+const apiKeyPrefix = "mtk_"
+
+// GenerateAPIKey returns an API key Token and the key itself.
+// Only the key hash is stored.
+func GenerateAPIKey(userID, name string, roles []string) (*models.Token, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return &models.Token{
+		Token:  APIKeyHash(key),
+		UserID: userID,
+		Roles:  roles,
+		Type:   "apikey",
+		ID:     uuid.NewV4().String(),
+		Name:   name,
+	}, key, nil
+}
+
+// IsAPIKey check if a token string is an API key.
+func IsAPIKey(tokenString string) bool {
+	return strings.HasPrefix(tokenString, apiKeyPrefix)
+}
+
+// APIKeyHash return the stored hash of an API key.
+func APIKeyHash(key string) string {
+	return core.Sha256(key)
+}
+
+// NewAPIKeyToken return a valid token for a user authenticated by an API key.
+func NewAPIKeyToken(id, userID string, roles []string) *jwt.Token {
+	token := NewToken(userID, roles)
+	token.Claims.(*AuthClaims).APIKey = id
+	return token
+}
+
+// NewToken return a valid token for a user authenticated without a metronome JWT.
+func NewToken(userID string, roles []string) *jwt.Token {
+	return &jwt.Token{
+		Claims: &AuthClaims{
+			Roles: roles,
+			StandardClaims: jwt.StandardClaims{
+				Subject: userID,
+			},
+		},
+		Valid: true,
+	}
+}
@@ -0,0 +1,195 @@
+package oauth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/spf13/viper"
+
+	"github.com/ovh/metronome/src/api/core/oidc"
+)
+
+// KeyConfig describe a token signing key.
+// Key is the hex secret of HMAC keys, or the PEM, inline or as a file path,
+// of RSA and ECDSA private keys.
+type KeyConfig struct {
+	ID  string `mapstructure:"kid"`
+	Alg string `mapstructure:"alg"`
+	Key string `mapstructure:"key"`
+}
+
+// Key is a token signing key.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// Keyring hold the token keys.
+// Tokens are signed with the signing key and verified with any key,
+// a key must be kept until the tokens it signed have expired.
+type Keyring struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+var (
+	keyringOnce sync.Once
+	keyring     *Keyring
+	keyringErr  error
+)
+
+// Keys return the keyring configured by token.keys and token.signing.
+// The legacy token.key, a HS512 key without id, is only used when token.keys is empty.
+// To keep it while migrating, list it in token.keys without kid.
+func Keys() (*Keyring, error) {
+	keyringOnce.Do(func() {
+		var configs []KeyConfig
+		if err := viper.UnmarshalKey("token.keys", &configs); err != nil {
+			keyringErr = err
+			return
+		}
+
+		signing := viper.GetString("token.signing")
+		if len(configs) == 0 && viper.IsSet("token.key") {
+			configs = append(configs, KeyConfig{
+				Alg: "HS512",
+				Key: viper.GetString("token.key"),
+			})
+		}
+
+		keyring, keyringErr = NewKeyring(configs, signing)
+	})
+	return keyring, keyringErr
+}
+
+// NewKeyring return a keyring signing with the key of id signing.
+func NewKeyring(configs []KeyConfig, signing string) (*Keyring, error) {
+	kr := &Keyring{
+		keys: make(map[string]*Key),
+	}
+
+	for _, c := range configs {
+		k, err := newKey(c)
+		if err != nil {
+			return nil, fmt.Errorf("Bad token key '%s': %v", c.ID, err)
+		}
+
+		if _, ok := kr.keys[k.ID]; ok {
+			return nil, fmt.Errorf("Duplicated token key '%s'", k.ID)
+		}
+		kr.keys[k.ID] = k
+	}
+
+	signingKey, ok := kr.keys[signing]
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing token key '%s'", signing)
+	}
+	kr.signing = signingKey
+
+	return kr, nil
+}
+
+// newKey parse a key config.
+func newKey(c KeyConfig) (*Key, error) {
+	k := &Key{
+		ID:     c.ID,
+		Method: jwt.GetSigningMethod(c.Alg),
+	}
+
+	switch k.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		secret, err := hex.DecodeString(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		k.private = secret
+		k.public = secret
+	case *jwt.SigningMethodRSA:
+		pem, err := readPEM(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		k.private = private
+		k.public = &private.PublicKey
+	case *jwt.SigningMethodECDSA:
+		pem, err := readPEM(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		private, err := jwt.ParseECPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		k.private = private
+		k.public = &private.PublicKey
+	default:
+		return nil, fmt.Errorf("Unsupported algorithm '%s'", c.Alg)
+	}
+
+	return k, nil
+}
+
+// readPEM return an inline PEM or read it from a file.
+func readPEM(key string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(key), "-----BEGIN") {
+		return []byte(key), nil
+	}
+	return ioutil.ReadFile(key)
+}
+
+// Sign a token with the signing key.
+func (kr *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(kr.signing.Method, claims)
+	if len(kr.signing.ID) > 0 {
+		token.Header["kid"] = kr.signing.ID
+	}
+
+	return token.SignedString(kr.signing.private)
+}
+
+// Parse a token signed by one of the keys.
+func (kr *Keyring) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		k, ok := kr.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("Unknown key id '%s'", kid)
+		}
+
+		if token.Method.Alg() != k.Method.Alg() {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return k.public, nil
+	})
+}
+
+// JWKS return the public keys, HMAC keys are never exposed.
+func (kr *Keyring) JWKS() ([]*oidc.JWK, error) {
+	jwks := []*oidc.JWK{}
+	for _, k := range kr.keys {
+		if _, ok := k.Method.(*jwt.SigningMethodHMAC); ok {
+			continue
+		}
+
+		jwk, err := oidc.NewJWK(k.ID, k.public)
+		if err != nil {
+			return nil, err
+		}
+		jwks = append(jwks, jwk)
+	}
+
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].Kid < jwks[j].Kid })
+	return jwks, nil
+}
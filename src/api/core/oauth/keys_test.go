@@ -0,0 +1,158 @@
+package oauth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/ovh/metronome/src/api/core/oauth"
+)
+
+func rsaKey(kid string) oauth.KeyConfig {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	return oauth.KeyConfig{
+		ID:  kid,
+		Alg: "RS256",
+		Key: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+	}
+}
+
+func ecKey(kid string) oauth.KeyConfig {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Ω(err).ShouldNot(HaveOccurred())
+	der, err := x509.MarshalECPrivateKey(key)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	return oauth.KeyConfig{
+		ID:  kid,
+		Alg: "ES256",
+		Key: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+	}
+}
+
+func hmacKey(kid string) oauth.KeyConfig {
+	return oauth.KeyConfig{
+		ID:  kid,
+		Alg: "HS512",
+		Key: "0323354b2b0fefbda5efbdbce3839f06",
+	}
+}
+
+func claims() *oauth.AuthClaims {
+	return &oauth.AuthClaims{
+		Roles: []string{"admin"},
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			Subject:   "user",
+		},
+	}
+}
+
+var _ = Describe("Keyring", func() {
+	roundTrip := func(config oauth.KeyConfig) {
+		kr, err := oauth.NewKeyring([]oauth.KeyConfig{config}, config.ID)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		s, err := kr.Sign(claims())
+		Ω(err).ShouldNot(HaveOccurred())
+
+		token, err := kr.Parse(s, &oauth.AuthClaims{})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(token.Valid).Should(BeTrue())
+		Ω(token.Header["kid"]).Should(Equal(config.ID))
+		Ω(oauth.UserID(token)).Should(Equal("user"))
+	}
+
+	It("Sign RS256", func() {
+		roundTrip(rsaKey("rsa"))
+	})
+
+	It("Sign ES256", func() {
+		roundTrip(ecKey("ec"))
+	})
+
+	It("Sign HS512", func() {
+		roundTrip(hmacKey("hmac"))
+	})
+
+	It("Unknown signing key", func() {
+		_, err := oauth.NewKeyring([]oauth.KeyConfig{rsaKey("rsa")}, "other")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("Bad algorithm", func() {
+		config := rsaKey("rsa")
+		config.Alg = "none"
+		_, err := oauth.NewKeyring([]oauth.KeyConfig{config}, "rsa")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("Rotation", func() {
+		old, next := rsaKey("old"), ecKey("next")
+
+		before, err := oauth.NewKeyring([]oauth.KeyConfig{old}, "old")
+		Ω(err).ShouldNot(HaveOccurred())
+		s, err := before.Sign(claims())
+		Ω(err).ShouldNot(HaveOccurred())
+
+		// the old key keep validating once the signing key rotated
+		during, err := oauth.NewKeyring([]oauth.KeyConfig{old, next}, "next")
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = during.Parse(s, &oauth.AuthClaims{})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		rotated, err := during.Sign(claims())
+		Ω(err).ShouldNot(HaveOccurred())
+		token, err := during.Parse(rotated, &oauth.AuthClaims{})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(token.Header["kid"]).Should(Equal("next"))
+
+		// then the old key is removed
+		after, err := oauth.NewKeyring([]oauth.KeyConfig{next}, "next")
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = after.Parse(s, &oauth.AuthClaims{})
+		Ω(err).Should(HaveOccurred())
+		_, err = after.Parse(rotated, &oauth.AuthClaims{})
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Algorithm mismatch", func() {
+		config := rsaKey("rsa")
+		kr, err := oauth.NewKeyring([]oauth.KeyConfig{config}, "rsa")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		// HMAC signed with the RSA public key
+		jwks, err := kr.JWKS()
+		Ω(err).ShouldNot(HaveOccurred())
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+		token.Header["kid"] = "rsa"
+		s, err := token.SignedString([]byte(jwks[0].N))
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = kr.Parse(s, &oauth.AuthClaims{})
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("JWKS", func() {
+		kr, err := oauth.NewKeyring([]oauth.KeyConfig{rsaKey("rsa"), ecKey("ec"), hmacKey("hmac")}, "rsa")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		jwks, err := kr.JWKS()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(jwks).Should(HaveLen(2))
+		Ω(jwks[0].Kid).Should(Equal("ec"))
+		Ω(jwks[0].Alg).Should(Equal("ES256"))
+		Ω(jwks[1].Kid).Should(Equal("rsa"))
+		Ω(jwks[1].Kty).Should(Equal("RSA"))
+	})
+})
@@ -0,0 +1,12 @@
+package oauth_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestOAuth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "API OAuth Suite")
+}
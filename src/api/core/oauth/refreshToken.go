@@ -1,18 +1,44 @@
 package oauth
 
 import (
+	"crypto/rand"
 	"encoding/base64"
+	"time"
 
-	"github.com/ovh/metronome/src/api/models"
 	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+
+	"github.com/ovh/metronome/src/api/models"
+	"github.com/ovh/metronome/src/metronome/core"
 )
 
-// GenerateRefreshToken returns an Token
-func GenerateRefreshToken(userID string, roles []string) (*models.Token, error) {
+// GenerateRefreshToken returns a refresh Token of a session and the refresh token itself.
+// Only the refresh token hash is stored, it expire after token.refresh.ttl seconds.
+func GenerateRefreshToken(userID string, roles []string, session string) (*models.Token, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+
+	refreshToken := base64.RawURLEncoding.EncodeToString(secret)
+	expiresAt := time.Now().Add(time.Duration(viper.GetInt("token.refresh.ttl")) * time.Second)
 	return &models.Token{
-		Token:  base64.StdEncoding.EncodeToString(uuid.NewV4().Bytes()),
-		UserID: userID,
-		Roles:  roles,
-		Type:   "refresh",
-	}, nil
+		Token:     RefreshTokenHash(refreshToken),
+		UserID:    userID,
+		Roles:     roles,
+		Type:      "refresh",
+		ID:        uuid.NewV4().String(),
+		Family:    session,
+		ExpiresAt: &expiresAt,
+	}, refreshToken, nil
+}
+
+// NewSession return a new session id, the family of its refresh tokens.
+func NewSession() string {
+	return uuid.NewV4().String()
+}
+
+// RefreshTokenHash return the stored hash of a refresh token.
+func RefreshTokenHash(refreshToken string) string {
+	return core.Sha256(refreshToken)
 }
@@ -0,0 +1,384 @@
+// Package oidc verify tokens issued by an external OpenID Connect provider.
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/spf13/viper"
+)
+
+// refreshInterval limit the JWKS refresh on unknown key ids.
+const refreshInterval = time.Minute
+
+// Config of an OpenID Connect provider.
+type Config struct {
+	Issuer   string
+	Audience string
+	// JWKS url, discovered from the issuer if empty
+	JWKS string
+	// NameClaim and RolesClaim map the token claims to the user,
+	// nested claims are dot separated
+	NameClaim  string
+	RolesClaim string
+}
+
+// Identity is an user authenticated by the provider.
+type Identity struct {
+	Issuer  string
+	Subject string
+	Name    string
+	Roles   []string
+}
+
+// Provider verify the tokens of an issuer.
+type Provider struct {
+	config    Config
+	client    *http.Client
+	lock      sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+var (
+	once     sync.Once
+	provider *Provider
+)
+
+// Default return the provider configured by oidc.*.
+// Return nil if no issuer is configured.
+func Default() *Provider {
+	once.Do(func() {
+		if len(viper.GetString("oidc.issuer")) == 0 {
+			return
+		}
+
+		provider = NewProvider(Config{
+			Issuer:     viper.GetString("oidc.issuer"),
+			Audience:   viper.GetString("oidc.audience"),
+			JWKS:       viper.GetString("oidc.jwks"),
+			NameClaim:  viper.GetString("oidc.claims.name"),
+			RolesClaim: viper.GetString("oidc.claims.roles"),
+		})
+	})
+	return provider
+}
+
+// NewProvider return a new provider.
+func NewProvider(config Config) *Provider {
+	if len(config.NameClaim) == 0 {
+		config.NameClaim = "preferred_username"
+	}
+	if len(config.RolesClaim) == 0 {
+		config.RolesClaim = "roles"
+	}
+
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]interface{}),
+	}
+}
+
+// Issues check if a token claim to be issued by the provider.
+// The token is not verified.
+func (p *Provider) Issues(tokenString string) bool {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	payload, err := jwt.DecodeSegment(parts[1])
+	if err != nil {
+		return false
+	}
+
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return false
+	}
+	return claims.Issuer == p.config.Issuer
+}
+
+// Verify a token issued by the provider.
+func (p *Provider) Verify(tokenString string) (*Identity, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		return p.key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("Token is not valid")
+	}
+
+	if !claims.VerifyIssuer(p.config.Issuer, true) {
+		return nil, errors.New("Token issuer mismatch")
+	}
+
+	if !audience(claims["aud"], p.config.Audience) {
+		return nil, errors.New("Token audience mismatch")
+	}
+
+	sub, _ := claims["sub"].(string)
+	if len(sub) == 0 {
+		return nil, errors.New("Token has no subject")
+	}
+
+	identity := &Identity{
+		Issuer:  p.config.Issuer,
+		Subject: sub,
+		Name:    sub,
+	}
+
+	if name, ok := lookup(claims, p.config.NameClaim).(string); ok && len(name) > 0 {
+		identity.Name = name
+	}
+
+	if roles, ok := lookup(claims, p.config.RolesClaim).([]interface{}); ok {
+		for _, r := range roles {
+			if role, ok := r.(string); ok {
+				identity.Roles = append(identity.Roles, role)
+			}
+		}
+	}
+
+	return identity, nil
+}
+
+// key return the verification key of a key id.
+// The JWKS is refreshed on unknown key ids.
+func (p *Provider) key(kid string) (interface{}, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if k := p.find(kid); k != nil {
+		return k, nil
+	}
+
+	if time.Since(p.fetchedAt) < refreshInterval {
+		return nil, fmt.Errorf("Unknown key id '%s'", kid)
+	}
+
+	keys, err := p.fetch()
+	p.fetchedAt = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+
+	if k := p.find(kid); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("Unknown key id '%s'", kid)
+}
+
+// find a key by id, a single key match tokens without key id.
+func (p *Provider) find(kid string) interface{} {
+	if len(kid) == 0 && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k
+		}
+	}
+	return p.keys[kid]
+}
+
+// fetch the provider JWKS.
+func (p *Provider) fetch() (map[string]interface{}, error) {
+	uri := p.config.JWKS
+	if len(uri) == 0 {
+		var discovery struct {
+			JWKS string `json:"jwks_uri"`
+		}
+		if err := p.get(strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		uri = discovery.JWKS
+	}
+
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := p.get(uri, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+
+		k, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = k
+	}
+	return keys, nil
+}
+
+// get a JSON document.
+func (p *Provider) get(uri string, v interface{}) error {
+	res, err := p.client.Get(uri)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status %d from %s", res.StatusCode, uri)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// JWK is a JSON web key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// PublicKey return the public key of a JWK.
+func (jwk *JWK) PublicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("Unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported key type '%s'", jwk.Kty)
+	}
+}
+
+// NewJWK return the JWK of a RSA or P-256 public key.
+func NewJWK(kid string, key interface{}) (*JWK, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   encodeInt(k.N.Bytes()),
+			E:   encodeInt(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("Unsupported curve")
+		}
+		// coordinates are padded to the curve size
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return &JWK{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Alg: "ES256",
+			Crv: "P-256",
+			X:   encodeInt(pad(k.X.Bytes(), size)),
+			Y:   encodeInt(pad(k.Y.Bytes(), size)),
+		}, nil
+	default:
+		return nil, errors.New("Unsupported key type")
+	}
+}
+
+// encodeInt encode a big-endian integer as base64url.
+func encodeInt(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// pad left a big-endian integer to a size.
+func pad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// decodeInt decode a base64url big-endian integer.
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// audience check if the aud claim, a string or a list, contains the audience.
+func audience(aud interface{}, expected string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == expected
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookup a dot separated claim.
+func lookup(claims map[string]interface{}, path string) interface{} {
+	var v interface{} = claims
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
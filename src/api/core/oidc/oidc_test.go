@@ -0,0 +1,188 @@
+package oidc_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/ovh/metronome/src/api/core/oidc"
+)
+
+// issuer is a local stand-in OpenID Connect provider.
+type issuer struct {
+	server *httptest.Server
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+}
+
+func newIssuer() *issuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	Ω(err).ShouldNot(HaveOccurred())
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	i := &issuer{rsa: rsaKey, ec: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		Ω(json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   i.server.URL,
+			"jwks_uri": i.server.URL + "/keys",
+		})).Should(Succeed())
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		rsaJWK, err := oidc.NewJWK("rsa", &i.rsa.PublicKey)
+		Ω(err).ShouldNot(HaveOccurred())
+		ecJWK, err := oidc.NewJWK("ec", &i.ec.PublicKey)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []*oidc.JWK{rsaJWK, ecJWK},
+		})).Should(Succeed())
+	})
+	i.server = httptest.NewServer(mux)
+
+	return i
+}
+
+func (i *issuer) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                i.server.URL,
+		"aud":                "metronome",
+		"sub":                "42",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"preferred_username": "alice",
+		"realm_access": map[string]interface{}{
+			"roles": []string{"admin"},
+		},
+	}
+}
+
+func (i *issuer) sign(method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	var key interface{} = i.rsa
+	if method == jwt.SigningMethodES256 {
+		key = i.ec
+	}
+
+	s, err := token.SignedString(key)
+	Ω(err).ShouldNot(HaveOccurred())
+	return s
+}
+
+var _ = Describe("Provider", func() {
+	var (
+		i *issuer
+		p *oidc.Provider
+	)
+
+	BeforeEach(func() {
+		i = newIssuer()
+		p = oidc.NewProvider(oidc.Config{
+			Issuer:     i.server.URL,
+			Audience:   "metronome",
+			RolesClaim: "realm_access.roles",
+		})
+	})
+
+	AfterEach(func() {
+		i.server.Close()
+	})
+
+	It("Issues", func() {
+		Ω(p.Issues(i.sign(jwt.SigningMethodRS256, "rsa", i.claims()))).Should(BeTrue())
+
+		claims := i.claims()
+		claims["iss"] = "https://other.example.com"
+		Ω(p.Issues(i.sign(jwt.SigningMethodRS256, "rsa", claims))).Should(BeFalse())
+		Ω(p.Issues("not a token")).Should(BeFalse())
+	})
+
+	It("Verify RS256", func() {
+		identity, err := p.Verify(i.sign(jwt.SigningMethodRS256, "rsa", i.claims()))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(identity.Subject).Should(Equal("42"))
+		Ω(identity.Name).Should(Equal("alice"))
+		Ω(identity.Roles).Should(Equal([]string{"admin"}))
+	})
+
+	It("Verify ES256", func() {
+		identity, err := p.Verify(i.sign(jwt.SigningMethodES256, "ec", i.claims()))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(identity.Subject).Should(Equal("42"))
+	})
+
+	It("Audience list", func() {
+		claims := i.claims()
+		claims["aud"] = []string{"other", "metronome"}
+		_, err := p.Verify(i.sign(jwt.SigningMethodRS256, "rsa", claims))
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Bad audience", func() {
+		claims := i.claims()
+		claims["aud"] = "other"
+		_, err := p.Verify(i.sign(jwt.SigningMethodRS256, "rsa", claims))
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("Bad issuer", func() {
+		claims := i.claims()
+		claims["iss"] = "https://other.example.com"
+		_, err := p.Verify(i.sign(jwt.SigningMethodRS256, "rsa", claims))
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("Expired", func() {
+		claims := i.claims()
+		claims["exp"] = time.Now().Add(-time.Hour).Unix()
+		_, err := p.Verify(i.sign(jwt.SigningMethodRS256, "rsa", claims))
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("Unknown key", func() {
+		_, err := p.Verify(i.sign(jwt.SigningMethodRS256, "unknown", i.claims()))
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("Bad signature", func() {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, i.claims())
+		token.Header["kid"] = "rsa"
+		s, err := token.SignedString(other)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = p.Verify(s)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("HMAC", func() {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, i.claims())
+		s, err := token.SignedString([]byte("secret"))
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = p.Verify(s)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("Default name", func() {
+		claims := i.claims()
+		delete(claims, "preferred_username")
+		identity, err := p.Verify(i.sign(jwt.SigningMethodRS256, "rsa", claims))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(identity.Name).Should(Equal("42"))
+	})
+})
@@ -0,0 +1,22 @@
+package core
+
+import (
+	"context"
+	"net/http"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+type principalKey struct{}
+
+// WithPrincipal return a request carrying its authenticated token.
+func WithPrincipal(r *http.Request, token *jwt.Token) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, token))
+}
+
+// Principal return the authenticated token of a request.
+// Return nil on public routes.
+func Principal(r *http.Request) *jwt.Token {
+	token, _ := r.Context().Value(principalKey{}).(*jwt.Token)
+	return token
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/factories"
+	"github.com/ovh/metronome/src/metronome/redis"
+)
+
+// Bucket defined a token bucket, refilled at Rate tokens per second up to Burst tokens.
+type Bucket struct {
+	Rate  float64
+	Burst int
+}
+
+// enabled check if the bucket limit requests.
+func (b Bucket) enabled() bool {
+	return b.Rate > 0 && b.Burst > 0
+}
+
+// RateLimiter is a negroni middleware limiting the requests per IP and per user.
+// Buckets are stored in redis to be shared by the api replicas.
+type RateLimiter struct {
+	IP   Bucket
+	User Bucket
+	// Identify return the user of a request, empty if anonymous
+	Identify func(r *http.Request) string
+}
+
+// NewRateLimiter return a rate limiter configured by ratelimit.ip and ratelimit.user,
+// each with a rate and a burst. A bucket without rate does not limit.
+func NewRateLimiter(identify func(r *http.Request) string) *RateLimiter {
+	return &RateLimiter{
+		IP: Bucket{
+			Rate:  viper.GetFloat64("ratelimit.ip.rate"),
+			Burst: viper.GetInt("ratelimit.ip.burst"),
+		},
+		User: Bucket{
+			Rate:  viper.GetFloat64("ratelimit.user.rate"),
+			Burst: viper.GetInt("ratelimit.user.burst"),
+		},
+		Identify: identify,
+	}
+}
+
+func (rl *RateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if rl.IP.enabled() {
+		if !rl.take(w, "ip:"+ClientIP(r), rl.IP) {
+			return
+		}
+	}
+
+	if rl.User.enabled() && rl.Identify != nil {
+		if user := rl.Identify(r); len(user) > 0 {
+			if !rl.take(w, "user:"+user, rl.User) {
+				return
+			}
+		}
+	}
+
+	next(w, r)
+}
+
+// take a token from a bucket, reply 429 if none is available.
+// Requests are not limited if redis fail.
+func (rl *RateLimiter) take(w http.ResponseWriter, key string, bucket Bucket) bool {
+	wait, err := redis.DB().Take(key, bucket.Rate, bucket.Burst)
+	if err != nil {
+		log.WithError(err).Error("Cannot take a rate limit token")
+		return true
+	}
+
+	if wait <= 0 {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	out.JSON(w, http.StatusTooManyRequests, factories.Error(errors.New("Too many requests")))
+	return false
+}
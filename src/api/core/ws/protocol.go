@@ -0,0 +1,146 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ovh/metronome/src/metronome/models"
+)
+
+// Frame types.
+const (
+	// FrameAuth authenticate the socket, or refresh its token
+	FrameAuth = "auth"
+	// FrameSubscribe add a subscription
+	FrameSubscribe = "subscribe"
+	// FrameUnsubscribe remove a subscription
+	FrameUnsubscribe = "unsubscribe"
+	// FrameAck acknowledge a client frame
+	FrameAck = "ack"
+	// FrameError reject a client frame, or report a socket failure
+	FrameError = "error"
+	// FrameEvent deliver an event to its subscriptions
+	FrameEvent = "event"
+)
+
+// Error codes.
+const (
+	ErrBadRequest   = "bad_request"
+	ErrUnauthorized = "unauthorized"
+	ErrExpired      = "expired"
+	ErrNotFound     = "not_found"
+	ErrUnavailable  = "unavailable"
+)
+
+// MaxSubscriptions is the maximum number of subscriptions of a socket.
+const MaxSubscriptions = 64
+
+// Frame is a message exchanged over a websocket.
+// ID is set by the client and echoed in the ack or error frame answering it.
+type Frame struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	// Token authenticate an auth frame
+	Token string `json:"token,omitempty"`
+	// Subscription is the subscription of an unsubscribe or ack frame
+	Subscription string `json:"subscription,omitempty"`
+	// Filter restrict the events of a subscribe frame
+	Filter *Filter `json:"filter,omitempty"`
+	// Subscriptions are the subscriptions matching an event frame
+	Subscriptions []string      `json:"subscriptions,omitempty"`
+	Event         *models.Event `json:"event,omitempty"`
+	Error         *FrameErr     `json:"error,omitempty"`
+}
+
+// FrameErr describe an error frame.
+type FrameErr struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Filter select events.
+// Each non empty field must match, an empty filter match every event.
+type Filter struct {
+	// Tasks are task GUIDs
+	Tasks []string `json:"tasks,omitempty"`
+	// States are execution state kinds, as succeeded or failed
+	States []string `json:"states,omitempty"`
+	// Types are event types, as task.created
+	Types []string `json:"types,omitempty"`
+}
+
+// Validate check a filter.
+func (f *Filter) Validate() error {
+	for _, s := range f.States {
+		if !models.IsStateKind(s) {
+			return fmt.Errorf("Unknown state kind %q", s)
+		}
+	}
+	return nil
+}
+
+// Match check if an event match the filter.
+func (f *Filter) Match(e *models.Event) bool {
+	if len(f.Tasks) > 0 && !contains(f.Tasks, e.Task) {
+		return false
+	}
+	if len(f.States) > 0 && !contains(f.States, e.Kind()) {
+		return false
+	}
+	if len(f.Types) > 0 && !contains(f.Types, e.Type) {
+		return false
+	}
+	return true
+}
+
+// Subscriptions are the filters of a socket by subscription id.
+type Subscriptions map[string]Filter
+
+// Match return the ids of the subscriptions matching an event.
+func (s Subscriptions) Match(e *models.Event) []string {
+	var ids []string
+	for id, f := range s {
+		if f.Match(e) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ParseFrame unserialize a client frame.
+func ParseFrame(msg string) (*Frame, error) {
+	var f Frame
+	if err := json.Unmarshal([]byte(msg), &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// SendFrame send a frame to the outbound channel.
+func (c *Client) SendFrame(f *Frame) error {
+	body, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	c.Send(string(body))
+	return nil
+}
+
+// Ack acknowledge a client frame.
+func (c *Client) Ack(id, subscription string) error {
+	return c.SendFrame(&Frame{Type: FrameAck, ID: id, Subscription: subscription})
+}
+
+// Error send an error frame.
+func (c *Client) Error(id, code, message string) error {
+	return c.SendFrame(&Frame{Type: FrameError, ID: id, Error: &FrameErr{code, message}})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
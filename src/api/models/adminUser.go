@@ -0,0 +1,10 @@
+package models
+
+// AdminUser is the struct which is exposed by the /admin/users endpoint.
+type AdminUser struct {
+	User
+	Tasks int `json:"tasks"`
+}
+
+// AdminUsers is a slice of AdminUser
+type AdminUsers []AdminUser
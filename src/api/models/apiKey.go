@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// APIKey is the struct which is exposed by the /keys endpoint.
+// The key is only exposed on creation, the token table hold its hash.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	UserID     string     `json:"user_id"`
+	Roles      []string   `json:"roles,omitempty"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// APIKeys is a slice of APIKey
+type APIKeys []APIKey
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Project holds project attributes.
+// A project own tasks shared by its members.
+type Project struct {
+	ID        string    `json:"id" sql:"project_id,pk"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Role of the current user in the project
+	Role string `json:"role,omitempty" sql:"-"`
+}
+
+// Projects defined an array of project.
+type Projects []Project
+
+// Member holds project membership attributes.
+type Member struct {
+	ProjectID string    `json:"project_id" sql:"project_id,pk"`
+	UserID    string    `json:"user_id" sql:"user_id,pk"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Members defined an array of member.
+type Members []Member
+
+const (
+	// RoleViewer can read the project tasks
+	RoleViewer = "viewer"
+	// RoleEditor can also create and delete the project tasks
+	RoleEditor = "editor"
+	// RoleAdmin can also manage the project members
+	RoleAdmin = "admin"
+)
+
+// roleLevels order the project roles.
+var roleLevels = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// ProjectRole return the token role granting a project role.
+func ProjectRole(projectID, role string) string {
+	return fmt.Sprintf("project:%s:%s", projectID, role)
+}
+
+// ParseProjectRole split a token role into a project and a project role.
+func ParseProjectRole(tokenRole string) (string, string, bool) {
+	parts := strings.Split(tokenRole, ":")
+	if len(parts) != 3 || parts[0] != "project" {
+		return "", "", false
+	}
+
+	if _, ok := roleLevels[parts[2]]; !ok {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// MinRole return the lowest of two project roles.
+func MinRole(a, b string) string {
+	if roleLevels[a] < roleLevels[b] {
+		return a
+	}
+	return b
+}
+
+// Grants check if a token role grant at least a project role.
+func Grants(tokenRole, projectID, role string) bool {
+	prefix := fmt.Sprintf("project:%s:", projectID)
+	if !strings.HasPrefix(tokenRole, prefix) {
+		return false
+	}
+
+	granted, ok := roleLevels[strings.TrimPrefix(tokenRole, prefix)]
+	return ok && granted >= roleLevels[role]
+}
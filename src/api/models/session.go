@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Session is the struct which is exposed by the /auth/sessions endpoint.
+// A session is a refresh token family, rotated on each refresh.
+type Session struct {
+	ID          string     `json:"id"`
+	RefreshedAt time.Time  `json:"refreshed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	Current     bool       `json:"current"`
+}
+
+// Sessions is a slice of Session
+type Sessions []Session
@@ -4,11 +4,19 @@ import "time"
 
 // Token describe token serialization.
 type Token struct {
-	Token     string    `db:"token"`
-	UserID    string    `db:"user_id"`
-	Roles     []string  `db:"roles"`
-	Type      string    `db:"type"`
-	CreatedAt time.Time `db:"created_at"`
+	Token      string     `db:"token"`
+	UserID     string     `db:"user_id"`
+	Roles      []string   `db:"roles"`
+	Type       string     `db:"type"`
+	CreatedAt  time.Time  `db:"created_at"`
+	ID         string     `db:"id"`
+	Name       string     `db:"name"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+	// Family is the session of a refresh token
+	Family    string     `db:"family"`
+	ExpiresAt *time.Time `db:"expires_at"`
+	// UsedAt is set once a refresh token is rotated
+	UsedAt *time.Time `db:"used_at"`
 }
 
 // Tokens is a slice of Token
@@ -11,6 +11,12 @@ type User struct {
 	Password  string    `json:"password,omitempty"`
 	Roles     []string  `json:"roles,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
+	// OwnerID is the owner of a service account
+	OwnerID string `json:"owner_id,omitempty"`
+	// ExternalID is the identity of a user provisioned by an OIDC issuer
+	ExternalID string `json:"external_id,omitempty"`
+	// DisabledAt is set while an admin disable the user
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
 }
 
 // Users defined an array of user.
@@ -0,0 +1,15 @@
+package routers
+
+import (
+	adminCtrl "github.com/ovh/metronome/src/api/controllers/admin"
+)
+
+// AdminRoutes defined users administration endpoints.
+var AdminRoutes = Routes{
+	Route{"Search users", "GET", "/users", adminCtrl.Users, WithRoles("admin")},
+	Route{"Disable user", "POST", "/users/{id:[0-9a-f-]{36}}/disable", adminCtrl.Disable, WithRoles("admin")},
+	Route{"Enable user", "POST", "/users/{id:[0-9a-f-]{36}}/enable", adminCtrl.Enable, WithRoles("admin")},
+	Route{"Logout user", "POST", "/users/{id:[0-9a-f-]{36}}/logout", adminCtrl.Logout, WithRoles("admin")},
+	Route{"Reset user password", "PUT", "/users/{id:[0-9a-f-]{36}}/password", adminCtrl.Password, WithRoles("admin")},
+	Route{"Delete user", "DELETE", "/users/{id:[0-9a-f-]{36}}", adminCtrl.Delete, WithRoles("admin")},
+}
@@ -0,0 +1,10 @@
+package routers
+
+import (
+	auditCtrl "github.com/ovh/metronome/src/api/controllers/audit"
+)
+
+// AuditRoutes defined audit log endpoints.
+var AuditRoutes = Routes{
+	Route{"Get audit events", "GET", "/", auditCtrl.All, Authenticated},
+}
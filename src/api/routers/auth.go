@@ -6,6 +6,8 @@ import (
 
 // AuthRoutes defined auth endpoints
 var AuthRoutes = Routes{
-	Route{"Get access token", "POST", "/", authCtrl.AuthHandler},
-	Route{"Logoff a user", "POST", "/logout", authCtrl.LogoutHandler},
+	Route{"Get access token", "POST", "/", authCtrl.AuthHandler, Public},
+	Route{"Logoff a user", "POST", "/logout", authCtrl.LogoutHandler, Authenticated},
+	Route{"Get sessions", "GET", "/sessions", authCtrl.SessionsHandler, Authenticated},
+	Route{"Revoke a session", "DELETE", "/sessions/{id:[0-9a-f-]{36}}", authCtrl.RevokeSessionHandler, Authenticated},
 }
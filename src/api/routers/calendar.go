@@ -0,0 +1,11 @@
+package routers
+
+import (
+	calendarCtrl "github.com/ovh/metronome/src/api/controllers/calendar"
+)
+
+// CalendarRoutes defined calendar endpoints.
+var CalendarRoutes = Routes{
+	Route{"Create calendar", "POST", "/", calendarCtrl.Create, Authenticated},
+	Route{"Delete calendar", "DELETE", "/{name:\\S{1,256}}", calendarCtrl.Delete, Authenticated},
+}
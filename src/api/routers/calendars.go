@@ -0,0 +1,10 @@
+package routers
+
+import (
+	calendarsCtrl "github.com/ovh/metronome/src/api/controllers/calendars"
+)
+
+// CalendarsRoutes defined calendars endpoints.
+var CalendarsRoutes = Routes{
+	Route{"Get calendars", "GET", "/", calendarsCtrl.All, Authenticated},
+}
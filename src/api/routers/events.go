@@ -0,0 +1,12 @@
+package routers
+
+import (
+	eventsCtrl "github.com/ovh/metronome/src/api/controllers/events"
+)
+
+// EventsRoutes defined server-sent events endpoints.
+var EventsRoutes = Routes{
+	// Stream authenticate itself, from the Authorization header or a ticket
+	Route{"Events", "GET", "/", eventsCtrl.Stream, Public},
+	Route{"Events ticket", "POST", "/ticket", eventsCtrl.Ticket, Authenticated},
+}
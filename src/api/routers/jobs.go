@@ -0,0 +1,14 @@
+package routers
+
+import (
+	jobsCtrl "github.com/ovh/metronome/src/api/controllers/jobs"
+)
+
+// JobsRoutes defined pull queue and async jobs endpoints.
+var JobsRoutes = Routes{
+	Route{"Pull jobs", "POST", "/pull", jobsCtrl.Pull, Authenticated},
+	Route{"Ack job", "POST", "/{id:[0-9a-f]{64}}/ack", jobsCtrl.Ack, Authenticated},
+	Route{"Nack job", "POST", "/{id:[0-9a-f]{64}}/nack", jobsCtrl.Nack, Authenticated},
+	Route{"Complete job", "POST", "/{id:[0-9a-f]{64}}/complete", jobsCtrl.Complete, Authenticated},
+	Route{"Heartbeat job", "POST", "/{id:[0-9a-f]{64}}/heartbeat", jobsCtrl.Heartbeat, Authenticated},
+}
@@ -0,0 +1,12 @@
+package routers
+
+import (
+	keysCtrl "github.com/ovh/metronome/src/api/controllers/keys"
+)
+
+// KeysRoutes defined API keys endpoints.
+var KeysRoutes = Routes{
+	Route{"Create API key", "POST", "/", keysCtrl.Create, Authenticated},
+	Route{"Get API keys", "GET", "/", keysCtrl.All, Authenticated},
+	Route{"Revoke API key", "DELETE", "/{id:[0-9a-f-]{36}}", keysCtrl.Revoke, Authenticated},
+}
@@ -0,0 +1,13 @@
+package routers
+
+import (
+	projectCtrl "github.com/ovh/metronome/src/api/controllers/project"
+)
+
+// ProjectRoutes defined project endpoints.
+var ProjectRoutes = Routes{
+	Route{"Create project", "POST", "/", projectCtrl.Create, Authenticated},
+	Route{"Get project members", "GET", "/{id:[0-9a-f-]{36}}/members", projectCtrl.Members, Authenticated},
+	Route{"Set project member", "PUT", "/{id:[0-9a-f-]{36}}/members/{user:[0-9a-f-]{36}}", projectCtrl.SetMember, Authenticated},
+	Route{"Remove project member", "DELETE", "/{id:[0-9a-f-]{36}}/members/{user:[0-9a-f-]{36}}", projectCtrl.RemoveMember, Authenticated},
+}
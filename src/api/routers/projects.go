@@ -0,0 +1,10 @@
+package routers
+
+import (
+	projectsCtrl "github.com/ovh/metronome/src/api/controllers/projects"
+)
+
+// ProjectsRoutes defined projects endpoints.
+var ProjectsRoutes = Routes{
+	Route{"Get projects", "GET", "/", projectsCtrl.All, Authenticated},
+}
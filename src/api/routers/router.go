@@ -2,10 +2,16 @@
 package routers
 
 import (
+	"errors"
 	"net/http"
 	"path"
 
 	"github.com/gorilla/mux"
+
+	"github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/api/core/io/out"
+	"github.com/ovh/metronome/src/api/factories"
+	authSrv "github.com/ovh/metronome/src/api/services/auth"
 )
 
 // Route defined an http endpoint.
@@ -14,6 +20,25 @@ type Route struct {
 	Method      string
 	Pattern     string
 	HandlerFunc http.HandlerFunc
+	Auth        Auth
+}
+
+// Auth defined the authentication required by an endpoint.
+type Auth struct {
+	Required bool
+	// Roles all required in the token
+	Roles []string
+}
+
+// Public endpoints are reachable without authentication.
+var Public = Auth{}
+
+// Authenticated endpoints require a valid token.
+var Authenticated = Auth{Required: true}
+
+// WithRoles endpoints require a valid token holding all the roles.
+func WithRoles(roles ...string) Auth {
+	return Auth{Required: true, Roles: roles}
 }
 
 // Routes defined multiple http endoints.
@@ -24,9 +49,19 @@ func InitRoutes() *mux.Router {
 	router := mux.NewRouter()
 	bind(router, "/task", TaskRoutes)
 	bind(router, "/tasks", TasksRoutes)
+	bind(router, "/calendar", CalendarRoutes)
+	bind(router, "/calendars", CalendarsRoutes)
+	bind(router, "/jobs", JobsRoutes)
+	bind(router, "/project", ProjectRoutes)
+	bind(router, "/projects", ProjectsRoutes)
 	bind(router, "/auth", AuthRoutes)
+	bind(router, "/keys", KeysRoutes)
 	bind(router, "/user", UserRoutes)
 	bind(router, "/ws", WsRoutes)
+	bind(router, "/events", EventsRoutes)
+	bind(router, "/.well-known", WellKnownRoutes)
+	bind(router, "/admin", AdminRoutes)
+	bind(router, "/audit", AuditRoutes)
 	return router
 }
 
@@ -38,14 +73,44 @@ func bind(router *mux.Router, base string, routes Routes) {
 			Methods(route.Method).
 			Path(p).
 			Name(route.Name).
-			HandlerFunc(route.HandlerFunc)
+			HandlerFunc(authorize(route.Auth, route.HandlerFunc))
 
 		if p != "/" {
 			router.
 				Methods(route.Method).
 				Path(p + "/").
 				Name(route.Name).
-				HandlerFunc(route.HandlerFunc)
+				HandlerFunc(authorize(route.Auth, route.HandlerFunc))
+		}
+	}
+}
+
+// authorize enforce the endpoint authentication.
+// The authenticated token is injected into the request context.
+func authorize(auth Auth, next http.HandlerFunc) http.HandlerFunc {
+	if !auth.Required {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := authSrv.GetToken(r.Header.Get("Authorization"))
+		if err != nil {
+			out.JSON(w, http.StatusInternalServerError, factories.Error(err))
+			return
 		}
+
+		if token == nil {
+			out.JSON(w, http.StatusUnauthorized, factories.Error(errors.New("Unauthorized")))
+			return
+		}
+
+		for _, role := range auth.Roles {
+			if !authSrv.HasRole(role, token) {
+				out.JSON(w, http.StatusForbidden, factories.Error(errors.New("Forbidden")))
+				return
+			}
+		}
+
+		next(w, core.WithPrincipal(r, token))
 	}
 }
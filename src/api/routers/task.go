@@ -6,6 +6,6 @@ import (
 
 // TaskRoutes defined task endpoints.
 var TaskRoutes = Routes{
-	Route{"Create task", "POST", "/", taskCtrl.Create},
-	Route{"Delete task", "DELETE", "/{id:\\S{1,256}}", taskCtrl.Delete},
+	Route{"Create task", "POST", "/", taskCtrl.Create, Authenticated},
+	Route{"Delete task", "DELETE", "/{id:\\S{1,256}}", taskCtrl.Delete, Authenticated},
 }
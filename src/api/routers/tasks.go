@@ -6,5 +6,5 @@ import (
 
 // TasksRoutes defined tasks endpoints.
 var TasksRoutes = Routes{
-	Route{"Get tasks", "GET", "/", tasksCtrl.All},
+	Route{"Get tasks", "GET", "/", tasksCtrl.All, Authenticated},
 }
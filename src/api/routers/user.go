@@ -6,7 +6,9 @@ import (
 
 // UserRoutes defined user endpoints.
 var UserRoutes = Routes{
-	Route{"Create a user", "POST", "/", userCtrl.Create},
-	Route{"Edit a user", "PATCH", "/", userCtrl.Edit},
-	Route{"Retrieve current user", "GET", "/", userCtrl.Current},
+	Route{"Create a user", "POST", "/", userCtrl.Create, Public},
+	Route{"Edit a user", "PATCH", "/", userCtrl.Edit, Authenticated},
+	Route{"Retrieve current user", "GET", "/", userCtrl.Current, Authenticated},
+	Route{"Create a service account", "POST", "/service-accounts", userCtrl.CreateServiceAccount, Authenticated},
+	Route{"Retrieve service accounts", "GET", "/service-accounts", userCtrl.ServiceAccounts, Authenticated},
 }
@@ -0,0 +1,10 @@
+package routers
+
+import (
+	wellknownCtrl "github.com/ovh/metronome/src/api/controllers/wellknown"
+)
+
+// WellKnownRoutes defined well-known endpoints.
+var WellKnownRoutes = Routes{
+	Route{"Get token keys", "GET", "/jwks.json", wellknownCtrl.JWKS, Public},
+}
@@ -6,5 +6,5 @@ import (
 
 // WsRoutes defined websockets endpoints.
 var WsRoutes = Routes{
-	Route{"Websocket", "GET", "/", wsCtrl.Join},
+	Route{"Websocket", "GET", "/", wsCtrl.Join, Public},
 }
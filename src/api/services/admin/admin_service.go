@@ -0,0 +1,228 @@
+// Package adminsrv handle users administration.
+package adminsrv
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	pgV5 "gopkg.in/pg.v5"
+
+	acore "github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/api/models"
+	projectssrv "github.com/ovh/metronome/src/api/services/projects"
+	usersrv "github.com/ovh/metronome/src/api/services/user"
+	mmodels "github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/pg"
+	"github.com/ovh/metronome/src/metronome/redis"
+)
+
+// Users search the users by name, with their tasks count.
+func Users(search string, limit, offset int) (models.AdminUsers, error) {
+	db := pg.DB()
+
+	var users models.Users
+	q := db.Model(&users).Order("name").Limit(limit).Offset(offset)
+	if len(search) > 0 {
+		q = q.Where("name ILIKE ?", "%"+search+"%")
+	}
+	if err := q.Select(); err != nil {
+		return nil, err
+	}
+
+	res := models.AdminUsers{}
+	if len(users) == 0 {
+		return res, nil
+	}
+
+	var ids []string
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+
+	var counts []struct {
+		UserID string
+		Count  int
+	}
+	_, err := db.Query(&counts, "SELECT user_id, count(*) AS count FROM tasks WHERE user_id IN (?) GROUP BY user_id", pgV5.In(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make(map[string]int)
+	for _, c := range counts {
+		tasks[c.UserID] = c.Count
+	}
+
+	for _, u := range users {
+		u.Password = "" // remove password hash
+		res = append(res, models.AdminUser{
+			User:  u,
+			Tasks: tasks[u.ID],
+		})
+	}
+	return res, nil
+}
+
+// Disable or enable a user.
+// Disabling a user revoke its sessions and access tokens.
+// Return false if the user is unknown.
+func Disable(userID string, disabled bool) (bool, error) {
+	db := pg.DB()
+
+	var disabledAt *time.Time
+	if disabled {
+		now := time.Now()
+		disabledAt = &now
+	}
+
+	res, err := db.Model(&models.User{}).
+		Set("disabled_at = ?", disabledAt).
+		Where("user_id = ?", userID).
+		Update()
+	if err != nil {
+		return false, err
+	}
+
+	if res.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if disabled {
+		return true, usersrv.Revoke(userID)
+	}
+	return true, nil
+}
+
+// Logout revoke the sessions and access tokens of a user.
+// Return false if the user is unknown.
+func Logout(userID string) (bool, error) {
+	found, err := exists(userID)
+	if err != nil || !found {
+		return false, err
+	}
+
+	return true, usersrv.Revoke(userID)
+}
+
+// Delete a user, its service accounts and everything they own.
+// Their tasks are tombstoned so schedulers and aggregators drop them,
+// except their project tasks which are handed over to another project admin.
+// Return false if the user is unknown, projectssrv.ErrLastAdmin if the user is the only admin of a project.
+func Delete(userID string) (bool, error) {
+	found, err := exists(userID)
+	if err != nil || !found {
+		return false, err
+	}
+
+	projects, err := projectssrv.SoleAdmin(userID)
+	if err != nil {
+		return true, err
+	}
+	if len(projects) > 0 {
+		return true, projectssrv.ErrLastAdmin
+	}
+
+	db := pg.DB()
+
+	var accounts models.Users
+	if err := db.Model(&accounts).Column("user_id").Where("owner_id = ?", userID).Select(); err != nil {
+		return false, err
+	}
+	for _, a := range accounts {
+		if _, err := Delete(a.ID); err != nil {
+			return false, err
+		}
+	}
+
+	if err := usersrv.Revoke(userID); err != nil {
+		return false, err
+	}
+
+	// Project tasks outlive their creator, they are handed over to a project admin
+	var tasks mmodels.Tasks
+	if err := db.Model(&tasks).Where("user_id = ?", userID).Select(); err != nil {
+		return false, err
+	}
+	successors := make(map[string]string)
+	for _, t := range tasks {
+		msg := mmodels.TaskTombstone(t.GUID)
+		if len(t.ProjectID) > 0 {
+			successor, ok := successors[t.ProjectID]
+			if !ok {
+				if successor, err = projectssrv.Successor(t.ProjectID, userID); err != nil {
+					return false, err
+				}
+				successors[t.ProjectID] = successor
+			}
+			if len(successor) > 0 {
+				t.UserID = successor
+				msg = t.ToKafka()
+			}
+		}
+
+		if _, _, err := acore.GetKafka().Producer.SendMessage(msg); err != nil {
+			log.Errorf("FAILED to send message: %s\n", err)
+			return false, err
+		}
+	}
+
+	var calendars mmodels.Calendars
+	if err := db.Model(&calendars).Column("name").Where("user_id = ?", userID).Select(); err != nil {
+		return false, err
+	}
+	for _, c := range calendars {
+		removed := &mmodels.Calendar{
+			Name:      c.Name,
+			UserID:    userID,
+			CreatedAt: time.Now(),
+			Removed:   true,
+		}
+		if _, _, err := acore.GetKafka().Producer.SendMessage(removed.ToKafka()); err != nil {
+			log.Errorf("FAILED to send message: %s\n", err)
+			return false, err
+		}
+	}
+
+	// The aggregators may not have processed the tombstones yet,
+	// drop the rows referencing the user right away
+	err = db.RunInTransaction(func(tx *pgV5.Tx) error {
+		for projectID, successor := range successors {
+			if len(successor) == 0 {
+				continue
+			}
+			_, err := tx.Model(&mmodels.Task{}).
+				Set("user_id = ?", successor).
+				Where("user_id = ?", userID).
+				Where("project_id = ?", projectID).
+				Update()
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, model := range []interface{}{&mmodels.Task{}, &mmodels.Calendar{}, &models.Token{}, &models.Member{}} {
+			if _, err := tx.Model(model).Where("user_id = ?", userID).Delete(); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Model(&models.User{}).Where("user_id = ?", userID).Delete()
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, redis.DB().Del(userID).Err()
+}
+
+// exists check if a user exists.
+func exists(userID string) (bool, error) {
+	db := pg.DB()
+
+	count, err := db.Model(&models.User{}).Where("user_id = ?", userID).Count()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
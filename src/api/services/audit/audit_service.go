@@ -0,0 +1,141 @@
+// Package auditsrv handle audit events.
+package auditsrv
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	acore "github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/pg"
+)
+
+// secrets are the fields masked in audit events.
+var secrets = []string{"password", "key", "token", "accessToken", "refreshToken", "secrets"}
+
+// Record an audit event of a change made by an actor through a request.
+// Only the fields changed between before and after are kept, secrets are masked.
+// The change is already made, failures are logged.
+func Record(r *http.Request, actorID, action, target string, before, after interface{}) {
+	b, a, err := diff(before, after)
+	if err != nil {
+		log.WithError(err).Errorf("Could not diff the audit event %s", action)
+	}
+
+	e := &models.AuditEvent{
+		ID:      uuid.NewV4().String(),
+		At:      time.Now(),
+		ActorID: actorID,
+		IP:      acore.ClientIP(r),
+		Action:  action,
+		Target:  target,
+		Before:  b,
+		After:   a,
+	}
+
+	if _, _, err := acore.GetKafka().Producer.SendMessage(e.ToKafka()); err != nil {
+		log.WithError(err).Errorf("Could not record the audit event %s", action)
+	}
+}
+
+// All retrieve the audit events, newest first.
+// Events are filtered by actor, action and target when set.
+func All(actorID, action, target string, limit, offset int) (models.AuditEvents, error) {
+	db := pg.DB()
+
+	events := models.AuditEvents{}
+	q := db.Model(&events).Order("at DESC").Limit(limit).Offset(offset)
+	if len(actorID) > 0 {
+		q = q.Where("actor_id = ?", actorID)
+	}
+	if len(action) > 0 {
+		q = q.Where("action = ?", action)
+	}
+	if len(target) > 0 {
+		q = q.Where("target = ?", target)
+	}
+
+	if err := q.Select(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// diff return the fields changed between before and after.
+func diff(before, after interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	b, err := toMap(before)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	a, err := toMap(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for k, v := range b {
+		if av, ok := a[k]; ok && reflect.DeepEqual(v, av) {
+			delete(b, k)
+			delete(a, k)
+		}
+	}
+
+	mask(b)
+	mask(a)
+	return b, a, nil
+}
+
+// toMap convert a value to its JSON fields.
+func toMap(v interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(out, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// mask the secret fields, nested ones included, as in task payloads.
+func mask(m map[string]interface{}) {
+	for k, v := range m {
+		if isSecret(k) {
+			m[k] = "***"
+			continue
+		}
+		maskValue(v)
+	}
+}
+
+// maskValue mask the secret fields of the objects of a JSON value.
+func maskValue(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		mask(v)
+	case []interface{}:
+		for _, e := range v {
+			maskValue(e)
+		}
+	}
+}
+
+func isSecret(field string) bool {
+	for _, s := range secrets {
+		if field == s {
+			return true
+		}
+	}
+	return false
+}
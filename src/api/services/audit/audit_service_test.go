@@ -0,0 +1,81 @@
+package auditsrv_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	auditSrv "github.com/ovh/metronome/src/api/services/audit"
+)
+
+var _ = Describe("Diff", func() {
+	It("Drop unchanged fields", func() {
+		before := map[string]interface{}{"name": "a", "schedule": "R/2017-01-01T00:00:00Z/PT1S/ET1S"}
+		after := map[string]interface{}{"name": "b", "schedule": "R/2017-01-01T00:00:00Z/PT1S/ET1S"}
+
+		b, a, err := auditSrv.Diff(before, after)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(b).Should(Equal(map[string]interface{}{"name": "a"}))
+		Ω(a).Should(Equal(map[string]interface{}{"name": "b"}))
+	})
+
+	It("Keep added and removed fields", func() {
+		before := map[string]interface{}{"name": "a"}
+		after := map[string]interface{}{"name": "a", "project_id": "p"}
+
+		b, a, err := auditSrv.Diff(before, after)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(b).Should(BeEmpty())
+		Ω(a).Should(Equal(map[string]interface{}{"project_id": "p"}))
+	})
+
+	It("Mask secrets", func() {
+		after := map[string]interface{}{"name": "a", "password": "p", "token": "t", "key": "k"}
+
+		b, a, err := auditSrv.Diff(nil, after)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(b).Should(BeNil())
+		Ω(a).Should(Equal(map[string]interface{}{"name": "a", "password": "***", "token": "***", "key": "***"}))
+	})
+
+	It("Mask nested secrets", func() {
+		after := map[string]interface{}{
+			"payload": map[string]interface{}{
+				"user":     "u",
+				"password": "p",
+				"auth": map[string]interface{}{
+					"token": "t",
+				},
+			},
+			"headers": []interface{}{
+				map[string]interface{}{"name": "n", "key": "k"},
+				"plain",
+			},
+		}
+
+		_, a, err := auditSrv.Diff(nil, after)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(a).Should(Equal(map[string]interface{}{
+			"payload": map[string]interface{}{
+				"user":     "u",
+				"password": "***",
+				"auth": map[string]interface{}{
+					"token": "***",
+				},
+			},
+			"headers": []interface{}{
+				map[string]interface{}{"name": "n", "key": "***"},
+				"plain",
+			},
+		}))
+	})
+
+	It("Mask changed secrets without leaking them", func() {
+		before := map[string]interface{}{"name": "a", "password": "old"}
+		after := map[string]interface{}{"name": "a", "password": "new"}
+
+		b, a, err := auditSrv.Diff(before, after)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(b).Should(Equal(map[string]interface{}{"password": "***"}))
+		Ω(a).Should(Equal(map[string]interface{}{"password": "***"}))
+	})
+})
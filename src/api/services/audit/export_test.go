@@ -0,0 +1,4 @@
+package auditsrv
+
+// Diff expose diff to the tests.
+var Diff = diff
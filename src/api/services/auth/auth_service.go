@@ -2,53 +2,326 @@
 package authsrv
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"net/http"
 	"strings"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 
 	"github.com/ovh/metronome/src/api/core/oauth"
+	"github.com/ovh/metronome/src/api/core/oidc"
 	"github.com/ovh/metronome/src/api/models"
-	"github.com/ovh/metronome/src/metronome/core"
+	usersrv "github.com/ovh/metronome/src/api/services/user"
 	"github.com/ovh/metronome/src/metronome/pg"
+	"github.com/ovh/metronome/src/metronome/redis"
 )
 
-// BearerTokensFromUser return both new Access and Refresh tokens.
+// BearerTokensFromUser return both new Access and Refresh tokens of a new session.
 func BearerTokensFromUser(user *models.User) (*models.BearerToken, error) {
+	return bearerTokens(user.ID, user.Roles, oauth.NewSession())
+}
+
+// bearerTokens issue an Access token and a new Refresh token of a session.
+func bearerTokens(userID string, userRoles []string, session string) (*models.BearerToken, error) {
 	db := pg.DB()
 
-	refreshToken, err := oauth.GenerateRefreshToken(user.ID, user.Roles)
+	token, refreshToken, err := oauth.GenerateRefreshToken(userID, userRoles, session)
 	if err != nil {
 		return nil, err
 	}
 
 	// We need to forward the refresh token to the client
-	// and store an encrypted version into our DB
-	res, err := db.Model(refreshToken).Insert()
+	// and store an hashed version into our DB
+	res, err := db.Model(token).Insert()
 	if err != nil || res.RowsAffected() == 0 {
 		return nil, err
 	}
 
-	accessToken, err := oauth.GenerateAccessToken(user.ID, user.Roles, refreshToken.Token)
+	roles, err := projectRoles(userID, userRoles)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := oauth.GenerateAccessToken(userID, roles, session)
 	if err != nil {
 		return nil, err
 	}
 
 	return &models.BearerToken{
 		AccessToken:  accessToken,
-		RefreshToken: refreshToken.Token,
+		RefreshToken: refreshToken,
 		Type:         "bearer",
 	}, nil
 }
 
-// GetToken return a token from a accessToken string.
+// GetToken return a token from a accessToken string or an API key.
 // Return nil if the accessToken string is invalid or if the token as expired.
 func GetToken(tokenString string) (*jwt.Token, error) {
 	if strings.HasPrefix(tokenString, "Bearer ") {
-		return oauth.GetToken(tokenString[7:])
+		tokenString = tokenString[7:]
+	}
+
+	if oauth.IsAPIKey(tokenString) {
+		return tokenFromAPIKey(tokenString)
+	}
+
+	if p := oidc.Default(); p != nil && p.Issues(tokenString) {
+		return tokenFromOIDC(tokenString)
+	}
+
+	token, err := oauth.GetToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := token.Claims.(*oauth.AuthClaims)
+	revoked, err := redis.DB().Revoked(claims.Id, claims.Session, claims.Subject, oauth.IssuedAt(token))
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, nil
+	}
+	return token, nil
+}
+
+// RequestUser identify the user of a request, to rate limit it, without database lookups.
+// API keys are identified by their hash. Return empty if the request is anonymous or unknown.
+func RequestUser(r *http.Request) string {
+	tokenString := r.Header.Get("Authorization")
+	if strings.HasPrefix(tokenString, "Bearer ") {
+		tokenString = tokenString[7:]
+	}
+
+	if len(tokenString) == 0 {
+		return ""
+	}
+
+	if oauth.IsAPIKey(tokenString) {
+		return "key:" + oauth.APIKeyHash(tokenString)
+	}
+
+	token, err := oauth.GetToken(tokenString)
+	if err != nil {
+		return ""
+	}
+	return oauth.UserID(token)
+}
+
+// revocationTTL is the access tokens lifetime, revocations are kept as long.
+func revocationTTL() time.Duration {
+	return time.Duration(viper.GetInt("token.ttl")) * time.Second
+}
+
+// UserFromOIDC return the user of a token issued by the OIDC issuer.
+// Return nil if no issuer is configured or if the token is invalid.
+func UserFromOIDC(tokenString string) (*models.User, error) {
+	p := oidc.Default()
+	if p == nil {
+		return nil, nil
+	}
+
+	identity, err := p.Verify(tokenString)
+	if err != nil {
+		log.WithError(err).Warn("Invalid OIDC token")
+		return nil, nil
+	}
+
+	return usersrv.FromIdentity(identity.Issuer+"|"+identity.Subject, identity.Name, identityRoles(identity.Roles))
+}
+
+// identityRoles return the issuer roles allowed by oidc.roles.
+// Project roles are granted by the project members only, never by the issuer.
+func identityRoles(roles []string) []string {
+	allowed := viper.GetStringSlice("oidc.roles")
+
+	res := []string{}
+	for _, r := range roles {
+		if strings.HasPrefix(r, "project:") {
+			continue
+		}
+		for _, a := range allowed {
+			if r == a {
+				res = append(res, r)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// tokenFromOIDC return a token from a token issued by the OIDC issuer.
+// Return nil if the token is invalid.
+func tokenFromOIDC(tokenString string) (*jwt.Token, error) {
+	user, err := UserFromOIDC(tokenString)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	roles, err := projectRoles(user.ID, user.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	return oauth.NewToken(user.ID, roles), nil
+}
+
+// lastUsedPrecision is the precision of the API keys last usage.
+const lastUsedPrecision = time.Minute
+
+// tokenFromAPIKey return a token from an API key.
+// Return nil if the API key is unknown.
+func tokenFromAPIKey(key string) (*jwt.Token, error) {
+	db := pg.DB()
+
+	var tokens models.Tokens
+	err := db.Model(&tokens).Where("token = ? AND type = 'apikey'", oauth.APIKeyHash(key)).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	apiKey := tokens[0]
+
+	var users models.Users
+	err = db.Model(&users).Where("user_id = ?", apiKey.UserID).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users) == 0 || users[0].DisabledAt != nil {
+		return nil, nil
+	}
+
+	roles, err := projectRoles(apiKey.UserID, users[0].Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the key usage at most once per lastUsedPrecision, not on every request
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= lastUsedPrecision {
+		_, err = db.Model(&apiKey).Set("last_used_at = ?", now).
+			Where("token = ?token AND (last_used_at IS NULL OR last_used_at < ?)", now.Add(-lastUsedPrecision)).
+			Update()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return oauth.NewAPIKeyToken(apiKey.ID, apiKey.UserID, scopeRoles(roles, apiKey.Roles)), nil
+}
+
+// scopeRoles restrict the user roles to the API key scopes.
+// A key without scopes hold all the user roles.
+func scopeRoles(roles, scopes []string) []string {
+	if len(scopes) == 0 {
+		return roles
+	}
+
+	var res []string
+	for _, r := range roles {
+		for _, s := range scopes {
+			if r == s {
+				res = append(res, r)
+				continue
+			}
+
+			// A project scope may lower a project role
+			rp, rr, ok := models.ParseProjectRole(r)
+			if !ok {
+				continue
+			}
+			sp, sr, ok := models.ParseProjectRole(s)
+			if ok && rp == sp {
+				res = append(res, models.ProjectRole(rp, models.MinRole(rr, sr)))
+			}
+		}
+	}
+	return res
+}
+
+// CreateAPIKey create a new API key for a user, scoped to roles if any.
+// The returned key is the only exposure of the key.
+func CreateAPIKey(userID, name string, roles []string) (*models.APIKey, error) {
+	db := pg.DB()
+
+	token, key, err := oauth.GenerateAPIKey(userID, name, roles)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Model(token).Insert(); err != nil {
+		return nil, err
+	}
+
+	apiKey := toAPIKey(*token)
+	apiKey.Key = key
+	apiKey.CreatedAt = time.Now()
+	return &apiKey, nil
+}
+
+// APIKeys retrieve the API keys of a user and of its service accounts.
+func APIKeys(userID string) (models.APIKeys, error) {
+	db := pg.DB()
+
+	var tokens models.Tokens
+	err := db.Model(&tokens).
+		Where("type = 'apikey'").
+		Where("(user_id = ? OR user_id IN (SELECT user_id FROM users WHERE owner_id = ?))", userID, userID).
+		Order("created_at").
+		Select()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := models.APIKeys{}
+	for _, t := range tokens {
+		keys = append(keys, toAPIKey(t))
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey remove an API key of a user or of its service accounts.
+// Return false if the API key is unknown.
+func RevokeAPIKey(userID, id string) (bool, error) {
+	db := pg.DB()
+
+	res, err := db.Model(&models.Token{}).
+		Where("id = ? AND type = 'apikey'", id).
+		Where("(user_id = ? OR user_id IN (SELECT user_id FROM users WHERE owner_id = ?))", userID, userID).
+		Delete()
+	if err != nil {
+		return false, err
 	}
 
-	return oauth.GetToken(tokenString)
+	return res.RowsAffected() > 0, nil
+}
+
+// IsAPIKey check if the token is authenticated by an API key.
+func IsAPIKey(token *jwt.Token) bool {
+	return len(oauth.APIKey(token)) > 0
+}
+
+// toAPIKey expose an API key token.
+func toAPIKey(t models.Token) models.APIKey {
+	return models.APIKey{
+		ID:         t.ID,
+		Name:       t.Name,
+		UserID:     t.UserID,
+		Roles:      t.Roles,
+		CreatedAt:  t.CreatedAt,
+		LastUsedAt: t.LastUsedAt,
+	}
 }
 
 // UserID return the user id from a token.
@@ -56,6 +329,16 @@ func UserID(token *jwt.Token) string {
 	return oauth.UserID(token)
 }
 
+// Session return the session of a token, empty if none.
+func Session(token *jwt.Token) string {
+	return oauth.Session(token)
+}
+
+// ExpiresAt return the expiration time of a token, zero if it never expires.
+func ExpiresAt(token *jwt.Token) time.Time {
+	return oauth.ExpiresAt(token)
+}
+
 // Roles return the roles from a token.
 func Roles(token *jwt.Token) []string {
 	return oauth.Roles(token)
@@ -71,66 +354,196 @@ func HasRole(role string, token *jwt.Token) bool {
 	return false
 }
 
-// getRefreshToken return a RefreshToken from PG
-// Returns nil if empty
-func getRefreshTokenFromDB(refreshToken string) (*models.Token, error) {
+// HasProjectRole check if the token grant at least a role on a project.
+// Access tokens outlive membership changes, the membership is read from the database.
+// API key roles are read on each request and restricted to the key scopes.
+func HasProjectRole(projectID, role string, token *jwt.Token) bool {
+	if len(oauth.APIKey(token)) > 0 {
+		for _, r := range Roles(token) {
+			if models.Grants(r, projectID, role) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var members models.Members
+	err := pg.DB().Model(&members).
+		Where("project_id = ?", projectID).
+		Where("user_id = ?", UserID(token)).
+		Select()
+	if err != nil {
+		log.WithError(err).Error("Could not read the project membership")
+		return false
+	}
+
+	return len(members) > 0 && models.Grants(models.ProjectRole(projectID, members[0].Role), projectID, role)
+}
+
+// projectRoles append the user project memberships to its roles.
+func projectRoles(userID string, roles []string) ([]string, error) {
 	db := pg.DB()
 
-	token := new(models.Token)
-	err := db.Model(token).Where("token = ? AND type = 'refresh'", refreshToken).Select()
+	var members models.Members
+	err := db.Model(&members).Where("user_id = ?", userID).Select()
 	if err != nil {
 		return nil, err
 	}
 
-	return token, nil
+	res := append([]string{}, roles...)
+	for _, m := range members {
+		res = append(res, models.ProjectRole(m.ProjectID, m.Role))
+	}
+	return res, nil
 }
 
-// BearerTokensFromRefresh return a new AccessToken
+// BearerTokensFromRefresh rotate a Refresh token and return new Access and Refresh tokens.
+// Reusing a rotated Refresh token revoke its whole session.
 func BearerTokensFromRefresh(refreshToken string) (*models.BearerToken, error) {
-	token, err := getRefreshTokenFromDB(refreshToken)
-	if err == pg.ErrNoRows {
+	db := pg.DB()
+
+	var tokens models.Tokens
+	err := db.Model(&tokens).Where("token = ? AND type = 'refresh'", oauth.RefreshTokenHash(refreshToken)).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
 		return nil, errors.New("No such refresh token")
 	}
+	token := tokens[0]
 
+	if token.ExpiresAt == nil || token.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("Refresh token expired")
+	}
+
+	// Only one refresh may rotate the token
+	res, err := db.Model(&models.Token{}).
+		Set("used_at = ?", time.Now()).
+		Where("token = ?", token.Token).
+		Where("used_at IS NULL").
+		Update()
 	if err != nil {
 		return nil, err
 	}
 
-	accessToken, err := oauth.GenerateAccessToken(token.UserID, token.Roles, refreshToken)
+	if res.RowsAffected() == 0 {
+		log.WithField("session", token.Family).Warn("Refresh token reuse, revoking the session")
+		if _, err := RevokeSession(token.UserID, token.Family); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("Refresh token reused")
+	}
+
+	// Drop the expired tokens
+	_, err = db.Model(&models.Token{}).
+		Where("user_id = ? AND type = 'refresh'", token.UserID).
+		Where("expires_at < ?", time.Now()).
+		Delete()
 	if err != nil {
 		return nil, err
 	}
 
-	return &models.BearerToken{
-		AccessToken:  accessToken,
-		Type:         "bearer",
-		RefreshToken: refreshToken,
-	}, nil
+	return bearerTokens(token.UserID, token.Roles, token.Family)
 }
 
-// RevokeRefreshTokenFromAccess remove a RefreshToken from DB from an accessToken
-func RevokeRefreshTokenFromAccess(token *jwt.Token) error {
+// Logout revoke an access token and its session.
+func Logout(token *jwt.Token) error {
+	if jti := oauth.ID(token); len(jti) > 0 {
+		if err := redis.DB().RevokeToken(jti, revocationTTL()); err != nil {
+			return err
+		}
+	}
+
+	session := oauth.Session(token)
+	if len(session) == 0 {
+		return nil
+	}
+
+	_, err := RevokeSession(oauth.UserID(token), session)
+	return err
+}
+
+// Sessions retrieve the active sessions of a user.
+// The session of the token is flagged as current.
+func Sessions(token *jwt.Token) (models.Sessions, error) {
 	db := pg.DB()
 
-	refreshTokenPlain, err := oauth.RefreshToken(token)
+	var tokens models.Tokens
+	err := db.Model(&tokens).
+		Where("user_id = ? AND type = 'refresh'", oauth.UserID(token)).
+		Where("used_at IS NULL").
+		Where("expires_at > ?", time.Now()).
+		Order("created_at DESC").
+		Select()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	users := models.Users{}
-	err = db.Model(&users).Where("user_id = ?", oauth.UserID(token)).Select()
+	sessions := models.Sessions{}
+	for _, t := range tokens {
+		sessions = append(sessions, models.Session{
+			ID:          t.Family,
+			RefreshedAt: t.CreatedAt,
+			ExpiresAt:   t.ExpiresAt,
+			Current:     t.Family == oauth.Session(token),
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession remove all the refresh tokens of a session of a user,
+// and revoke its access tokens.
+// Return false if the session is unknown, or of another user.
+func RevokeSession(userID, session string) (bool, error) {
+	db := pg.DB()
+
+	res, err := db.Model(&models.Token{}).
+		Where("user_id = ? AND type = 'refresh'", userID).
+		Where("family = ?", session).
+		Delete()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	if len(users) == 0 {
-		return nil
+	if res.RowsAffected() == 0 {
+		return false, nil
 	}
-	user := users[0]
 
-	// We need to regenerate the salt
-	ciphertext := core.PBKDF2(string(refreshTokenPlain), core.Sha256(user.ID))
-	var refreshToken models.Token
-	_, err = db.Model(&refreshToken).Where("token = ? AND type = 'refresh'", ciphertext).Delete()
-	return err
+	return true, redis.DB().RevokeSession(session, revocationTTL())
+}
+
+// ticketTTL is the lifetime of the tickets.
+const ticketTTL = 30 * time.Second
+
+// IssueTicket return a single use ticket authenticating as a token, valid for ticketTTL.
+// Tickets authenticate the clients unable to set headers, as the browsers event sources.
+func IssueTicket(token *jwt.Token) (string, error) {
+	claims, err := json.Marshal(token.Claims)
+	if err != nil {
+		return "", err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	ticket := hex.EncodeToString(secret)
+
+	return ticket, redis.DB().IssueTicket(ticket, string(claims), ticketTTL)
+}
+
+// RedeemTicket return the token of a ticket.
+// Return nil if the ticket is unknown, expired or already redeemed.
+func RedeemTicket(ticket string) (*jwt.Token, error) {
+	val, err := redis.DB().RedeemTicket(ticket)
+	if err != nil || len(val) == 0 {
+		return nil, err
+	}
+
+	var claims oauth.AuthClaims
+	if err := json.Unmarshal([]byte(val), &claims); err != nil {
+		return nil, err
+	}
+	return &jwt.Token{Claims: &claims, Valid: true}, nil
 }
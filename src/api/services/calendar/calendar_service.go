@@ -0,0 +1,54 @@
+// Package calendarsrv handle calendar Kafka messages.
+package calendarsrv
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	acore "github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/metronome/models"
+)
+
+// Create a new calendar or replace an existing one with the same name.
+// Return true if success.
+func Create(calendar *models.Calendar) bool {
+	calendar.CreatedAt = time.Now()
+	calendar.GUID = models.CalendarGUID(calendar.UserID, calendar.Name)
+
+	if calendar.Dates == nil {
+		calendar.Dates = []string{}
+	}
+	if calendar.Windows == nil {
+		calendar.Windows = []models.Window{}
+	}
+
+	k := acore.GetKafka()
+
+	_, _, err := k.Producer.SendMessage(calendar.ToKafka())
+	if err != nil {
+		log.Errorf("FAILED to send message: %s\n", err)
+		return false
+	}
+	return true
+}
+
+// Delete a calendar.
+// Return true if success.
+func Delete(name string, userID string) bool {
+	k := acore.GetKafka()
+
+	c := &models.Calendar{
+		Name:      name,
+		UserID:    userID,
+		CreatedAt: time.Now(),
+		Removed:   true,
+	}
+
+	_, _, err := k.Producer.SendMessage(c.ToKafka())
+	if err != nil {
+		log.Errorf("FAILED to send message: %s\n", err)
+		return false
+	}
+	return true
+}
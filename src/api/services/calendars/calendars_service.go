@@ -0,0 +1,43 @@
+// Package calendarssrv handle calendars database operations.
+package calendarssrv
+
+import (
+	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/pg"
+)
+
+// All retrieve all the calendars of a user.
+// Return nil if no calendar.
+func All(userID string) (models.Calendars, error) {
+	var calendars models.Calendars
+	db := pg.DB()
+
+	err := db.Model(&calendars).Where("user_id = ?", userID).Order("name").Select()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(calendars) == 0 {
+		return nil, nil
+	}
+
+	return calendars, nil
+}
+
+// Get retrieve a calendar of a user by name.
+// Return nil if the calendar is unknown.
+func Get(userID, name string) (*models.Calendar, error) {
+	var calendars models.Calendars
+	db := pg.DB()
+
+	err := db.Model(&calendars).Where("guid = ?", models.CalendarGUID(userID, name)).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(calendars) == 0 {
+		return nil, nil
+	}
+
+	return &calendars[0], nil
+}
@@ -0,0 +1,125 @@
+// Package jobssrv handle pull queue and async jobs operations.
+package jobssrv
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	acore "github.com/ovh/metronome/src/api/core"
+	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/redis"
+)
+
+// Pull jobs from a user or project queue.
+// Jobs are delivered up to jobs.pull.deliveries times.
+func Pull(owner, queue string, max int, wait, visibility time.Duration) ([]redis.QueuedJob, error) {
+	return redis.DB().Dequeue(owner, queue, max, wait, visibility, viper.GetInt("jobs.pull.deliveries"))
+}
+
+// Ack an in flight job, producing a success state.
+// Return false if the job is not in flight.
+func Ack(owner, id string, response interface{}) (bool, error) {
+	qj, err := redis.DB().Settle(owner, id)
+	if err != nil || qj == nil {
+		return false, err
+	}
+
+	unlock(qj.Job)
+
+	s := state(qj.ID, qj.Job, qj.PulledAt, models.Success)
+	if response != nil {
+		s.Response = &models.Response{
+			Status: 200,
+			Body:   response,
+		}
+	}
+
+	return true, send(s)
+}
+
+// Nack an in flight job.
+// The job is requeued, or a failure state is produced.
+// Return false if the job is not in flight.
+func Nack(owner, id string, requeue bool) (bool, error) {
+	qj, err := redis.DB().Settle(owner, id)
+	if err != nil || qj == nil {
+		return false, err
+	}
+
+	if requeue {
+		return true, redis.DB().Requeue(owner, *qj)
+	}
+
+	unlock(qj.Job)
+
+	return true, send(state(qj.ID, qj.Job, qj.PulledAt, models.Failed))
+}
+
+// Heartbeat renew the completion deadline of a running job.
+// Return false if the job is not running.
+func Heartbeat(owner, id string) (bool, error) {
+	return redis.DB().Heartbeat(owner, id)
+}
+
+// Complete a running job, producing a success or failure state.
+// Return false if the job is not running.
+func Complete(owner, id string, success bool, response interface{}) (bool, error) {
+	rj, err := redis.DB().Complete(owner, id)
+	if err != nil || rj == nil {
+		return false, err
+	}
+
+	unlock(rj.Job)
+
+	code := int64(models.Failed)
+	if success {
+		code = models.Success
+	}
+
+	s := state(rj.ID, rj.Job, rj.StartedAt, code)
+	if response != nil {
+		s.Response = &models.Response{
+			Status: 200,
+			Body:   response,
+		}
+	}
+
+	return true, send(s)
+}
+
+func state(id string, j models.Job, startedAt int64, code int64) models.State {
+	now := time.Now()
+	return models.State{
+		ID:        id,
+		TaskGUID:  j.GUID,
+		UserID:    j.UserID,
+		At:        j.At,
+		DoneAt:    now.Unix(),
+		Duration:  now.Sub(time.Unix(startedAt, 0)).Nanoseconds() / 1000,
+		URN:       j.URN,
+		State:     code,
+		Last:      j.Last,
+		ProjectID: j.ProjectID,
+	}
+}
+
+// unlock the job task if locked by its concurrency policy.
+func unlock(j models.Job) {
+	if !j.Locked() {
+		return
+	}
+
+	if err := redis.DB().Unlock(j.GUID, j.ID()); err != nil {
+		log.WithError(err).Warn("Could not unlock the task")
+	}
+}
+
+func send(s models.State) error {
+	_, _, err := acore.GetKafka().Producer.SendMessage(s.ToKafka())
+	if err != nil {
+		log.Errorf("FAILED to send message: %s\n", err)
+	}
+	return err
+}
@@ -0,0 +1,195 @@
+// Package projectssrv handle projects database operations.
+package projectssrv
+
+import (
+	"errors"
+
+	pgV5 "gopkg.in/pg.v5"
+
+	"github.com/ovh/metronome/src/api/models"
+	"github.com/ovh/metronome/src/metronome/pg"
+)
+
+// ErrLastAdmin is returned when a change would leave a project without admin.
+var ErrLastAdmin = errors.New("A project must keep at least one admin")
+
+// Create a new project, the user become its admin.
+func Create(userID string, project *models.Project) error {
+	db := pg.DB()
+
+	return db.RunInTransaction(func(tx *pgV5.Tx) error {
+		if _, err := tx.Model(project).Insert(); err != nil {
+			return err
+		}
+
+		member := &models.Member{
+			ProjectID: project.ID,
+			UserID:    userID,
+			Role:      models.RoleAdmin,
+		}
+		if _, err := tx.Model(member).Insert(); err != nil {
+			return err
+		}
+
+		project.Role = member.Role
+		return nil
+	})
+}
+
+// All retrieve all the projects of a user, with its role.
+// Return nil if no project.
+func All(userID string) (models.Projects, error) {
+	db := pg.DB()
+
+	var members models.Members
+	err := db.Model(&members).Where("user_id = ?", userID).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	roles := make(map[string]string)
+	var ids []string
+	for _, m := range members {
+		roles[m.ProjectID] = m.Role
+		ids = append(ids, m.ProjectID)
+	}
+
+	var projects models.Projects
+	err = db.Model(&projects).Where("project_id IN (?)", pgV5.In(ids)).Order("name").Select()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range projects {
+		projects[i].Role = roles[projects[i].ID]
+	}
+
+	return projects, nil
+}
+
+// Members retrieve the members of a project.
+func Members(projectID string) (models.Members, error) {
+	db := pg.DB()
+
+	var members models.Members
+	err := db.Model(&members).Where("project_id = ?", projectID).Order("created_at").Select()
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// SetMember add a user to a project or change its role.
+// Return false if the user is unknown.
+func SetMember(projectID, userID, role string) (bool, error) {
+	db := pg.DB()
+
+	count, err := db.Model(&models.User{}).Where("user_id = ?", userID).Count()
+	if err != nil || count == 0 {
+		return false, err
+	}
+
+	if role != models.RoleAdmin {
+		if err := keepAdmin(projectID, userID); err != nil {
+			return true, err
+		}
+	}
+
+	member := &models.Member{
+		ProjectID: projectID,
+		UserID:    userID,
+		Role:      role,
+	}
+	_, err = db.Model(member).OnConflict("(project_id, user_id) DO UPDATE").
+		Set("role = ?role").
+		Insert()
+	return true, err
+}
+
+// RemoveMember remove a user from a project.
+// Return false if the user is not a member.
+func RemoveMember(projectID, userID string) (bool, error) {
+	if err := keepAdmin(projectID, userID); err != nil {
+		return true, err
+	}
+
+	db := pg.DB()
+	res, err := db.Model(&models.Member{}).
+		Where("project_id = ?", projectID).
+		Where("user_id = ?", userID).
+		Delete()
+	if err != nil {
+		return false, err
+	}
+
+	return res.RowsAffected() > 0, nil
+}
+
+// SoleAdmin return the projects the user is the only admin of.
+func SoleAdmin(userID string) ([]string, error) {
+	db := pg.DB()
+
+	var members models.Members
+	err := db.Model(&members).
+		Where("user_id = ?", userID).
+		Where("role = ?", models.RoleAdmin).
+		Select()
+	if err != nil {
+		return nil, err
+	}
+
+	var projects []string
+	for _, m := range members {
+		err := keepAdmin(m.ProjectID, userID)
+		if err == ErrLastAdmin {
+			projects = append(projects, m.ProjectID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return projects, nil
+}
+
+// Successor return an admin of the project other than the user, empty if none.
+func Successor(projectID, userID string) (string, error) {
+	db := pg.DB()
+
+	var members models.Members
+	err := db.Model(&members).
+		Where("project_id = ?", projectID).
+		Where("user_id != ?", userID).
+		Where("role = ?", models.RoleAdmin).
+		Order("created_at").
+		Limit(1).
+		Select()
+	if err != nil || len(members) == 0 {
+		return "", err
+	}
+	return members[0].UserID, nil
+}
+
+// keepAdmin check that the project keep an admin other than the user.
+func keepAdmin(projectID, userID string) error {
+	db := pg.DB()
+
+	count, err := db.Model(&models.Member{}).
+		Where("project_id = ?", projectID).
+		Where("user_id != ?", userID).
+		Where("role = ?", models.RoleAdmin).
+		Count()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
@@ -30,14 +30,18 @@ func Create(task *models.Task) bool {
 	return true
 }
 
-// Delete a task.
+// Delete a task, owned by the project if any.
 // Return true if success.
-func Delete(id string, userID string) bool {
+func Delete(id string, userID string, projectID string) bool {
 	k := acore.GetKafka()
 
 	t := &models.Task{
-		ID:     id,
-		UserID: userID,
+		GUID: models.TaskGUID(userID, projectID, id),
+		ID:   id,
+	}
+	// Project tasks may be owned by another member, the aggregator look it up
+	if len(projectID) == 0 {
+		t.UserID = userID
 	}
 
 	_, _, err := k.Producer.SendMessage(t.ToKafka())
@@ -2,6 +2,8 @@
 package taskssrv
 
 import (
+	"gopkg.in/pg.v5/orm"
+
 	amodels "github.com/ovh/metronome/src/api/models"
 	"github.com/ovh/metronome/src/metronome/models"
 	"github.com/ovh/metronome/src/metronome/pg"
@@ -9,14 +11,14 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// All retrieve all the tasks of a user.
+// All retrieve all the tasks of a user, or of a project if set.
 // Return nil if no task.
-func All(userID string) (*amodels.TasksAns, error) {
+func All(userID, projectID string) (*amodels.TasksAns, error) {
 
 	var tasks models.Tasks
 	db := pg.DB()
 
-	err := db.Model(&tasks).Where("user_id = ?", userID).Select()
+	err := scope(db.Model(&tasks), userID, projectID).Select()
 	if err != nil {
 		return nil, err
 	}
@@ -25,15 +27,24 @@ func All(userID string) (*amodels.TasksAns, error) {
 		return nil, nil
 	}
 
-	states := redis.DB().HGetAll(userID)
-	if states.Err() != nil {
-		return nil, states.Err()
+	// States are stored by task owner
+	states := make(map[string]map[string]string)
+	for _, t := range tasks {
+		if _, ok := states[t.UserID]; ok {
+			continue
+		}
+
+		res := redis.DB().HGetAll(t.UserID)
+		if res.Err() != nil {
+			return nil, res.Err()
+		}
+		states[t.UserID] = res.Val()
 	}
 
 	var ans amodels.TasksAns
 	for _, t := range tasks {
 		var s models.State
-		state, ok := states.Val()[t.GUID]
+		state, ok := states[t.UserID][t.GUID]
 		if !ok {
 			log.Warnf("No such entry in map states for key '%s'", t.GUID)
 			ans = append(ans, amodels.TaskAns{
@@ -55,3 +66,64 @@ func All(userID string) (*amodels.TasksAns, error) {
 
 	return &ans, err
 }
+
+// Dependencies retrieve the dependency graph of the user tasks, or of a project if set.
+// The graph map a task ID to the IDs of its upstream tasks.
+func Dependencies(userID, projectID string) (map[string][]string, error) {
+	var tasks models.Tasks
+	db := pg.DB()
+
+	err := scope(db.Model(&tasks).Column("id", "after"), userID, projectID).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	graph := make(map[string][]string)
+	for _, t := range tasks {
+		graph[t.ID] = t.After
+	}
+
+	return graph, nil
+}
+
+// Get a task by GUID.
+// Return nil if the task is unknown.
+func Get(guid string) (*models.Task, error) {
+	var tasks models.Tasks
+	db := pg.DB()
+
+	if err := db.Model(&tasks).Where("guid = ?", guid).Select(); err != nil {
+		return nil, err
+	}
+
+	if len(tasks) == 0 {
+		return nil, nil
+	}
+	return &tasks[0], nil
+}
+
+// Active retrieve the tasks created by a user which will still run, projects included.
+// Quotas are accounted on these tasks.
+func Active(userID string) (models.Tasks, error) {
+	var tasks models.Tasks
+	db := pg.DB()
+
+	err := db.Model(&tasks).
+		Column("guid", "id", "user_id", "project_id", "schedule", "after").
+		Where("user_id = ?", userID).
+		Where("completed_at IS NULL").
+		Select()
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// scope restrict a query to the project tasks, or to the user own tasks.
+func scope(q *orm.Query, userID, projectID string) *orm.Query {
+	if len(projectID) > 0 {
+		return q.Where("project_id = ?", projectID)
+	}
+	return q.Where("user_id = ?", userID).Where("project_id IS NULL")
+}
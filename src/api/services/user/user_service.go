@@ -2,35 +2,96 @@
 package usersrv
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/spf13/viper"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/ovh/metronome/src/api/models"
+	"github.com/ovh/metronome/src/metronome/core"
 	"github.com/ovh/metronome/src/metronome/pg"
+	"github.com/ovh/metronome/src/metronome/redis"
 )
 
+// ErrLocked is returned while the logins of a user are locked after failed logins.
+var ErrLocked = errors.New("Too many failed logins, retry later")
+
 // Login made a lookup on the database base on username and perform password comparaison.
 // It return nil if the username is unknown or the password mismatch.
-func Login(username, password string) (*models.User, error) {
+// Service accounts, users provisioned by an OIDC issuer and disabled users cannot login.
+// Repeated failures lock the user logins from the client IP, ErrLocked is then returned.
+// Locking per client IP prevent anyone from locking a user out.
+func Login(username, password, ip string) (*models.User, error) {
+	locked, err := redis.DB().LoginLocked(username, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	if locked > 0 {
+		return nil, ErrLocked
+	}
+
 	db := pg.DB()
 
 	users := models.Users{}
-	err := db.Model(&users).Where("name = ?", username).Select()
+	err = db.Model(&users).
+		Where("name = ?", username).
+		Where("owner_id IS NULL").
+		Where("external_id IS NULL").
+		Where("disabled_at IS NULL").
+		Select()
 	if err != nil {
 		return nil, err
 	}
 
 	if len(users) == 0 {
-		return nil, err
+		return nil, loginFailed(username, ip)
 	}
 
 	user := users[0]
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, loginFailed(username, ip)
+	}
+
+	if err := redis.DB().LoginSucceeded(username, ip); err != nil {
 		return nil, err
 	}
 
 	return &user, nil
 }
 
+// loginFailed account a failed login from a client IP.
+// After login.lockout.threshold failures, the user logins from this IP are locked from login.lockout.delay seconds,
+// doubled on each new failure up to login.lockout.max seconds.
+func loginFailed(username, ip string) error {
+	max := time.Duration(viper.GetInt("login.lockout.max")) * time.Second
+	failures, err := redis.DB().LoginFailed(username, ip, max)
+	if err != nil {
+		return err
+	}
+
+	threshold := int64(viper.GetInt("login.lockout.threshold"))
+	if threshold <= 0 || failures < threshold {
+		return nil
+	}
+
+	lock := time.Duration(viper.GetInt("login.lockout.delay")) * time.Second
+	for i := threshold; i < failures && lock < max; i++ {
+		lock *= 2
+	}
+	if lock > max {
+		lock = max
+	}
+
+	if lock <= 0 {
+		return nil
+	}
+	return redis.DB().LockLogin(username, ip, lock)
+}
+
 // Create a new user into the database.
 // Return true if the username already exist.
 func Create(user *models.User) (bool, error) {
@@ -55,6 +116,7 @@ func Create(user *models.User) (bool, error) {
 }
 
 // Edit a user in the database.
+// Changing the password revoke the user sessions and access tokens.
 // Return true if the username already exist.
 func Edit(userID string, user *models.User) (bool, error) {
 	db := pg.DB()
@@ -75,6 +137,12 @@ func Edit(userID string, user *models.User) (bool, error) {
 		return false, err
 	}
 
+	if len(user.Password) > 0 {
+		if err := Revoke(userID); err != nil {
+			return false, err
+		}
+	}
+
 	user.Password = "" // remove password hash
 	return false, nil
 }
@@ -99,6 +167,118 @@ func Get(userID string) (*models.User, error) {
 	return &user, nil
 }
 
+// CreateServiceAccount create a new service account owned by a user.
+// Service accounts authenticate with API keys only.
+// Return true if the name already exist.
+func CreateServiceAccount(ownerID string, user *models.User) (bool, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return false, err
+	}
+
+	user.OwnerID = ownerID
+	user.Password = hex.EncodeToString(secret)
+	return Create(user)
+}
+
+// ServiceAccounts retrieve the service accounts owned by a user.
+func ServiceAccounts(ownerID string) (models.Users, error) {
+	db := pg.DB()
+
+	var users models.Users
+	err := db.Model(&users).Where("owner_id = ?", ownerID).Order("name").Select()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range users {
+		users[i].Password = "" // remove password hash
+	}
+	return users, nil
+}
+
+// Owns check if a user is the owner of a service account.
+func Owns(ownerID, userID string) (bool, error) {
+	db := pg.DB()
+
+	count, err := db.Model(&models.User{}).Where("user_id = ?", userID).Where("owner_id = ?", ownerID).Count()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// FromIdentity return the user of an external identity.
+// The user is provisioned on first login, its roles follow the issuer allowed ones.
+// Return nil if the user is disabled.
+func FromIdentity(externalID, name string, roles []string) (*models.User, error) {
+	db := pg.DB()
+
+	var users models.Users
+	err := db.Model(&users).Where("external_id = ?", externalID).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users) > 0 {
+		user := users[0]
+		if user.DisabledAt != nil {
+			return nil, nil
+		}
+
+		user.Roles = roles
+		if _, err := db.Model(&user).Column("roles").Update(); err != nil {
+			return nil, err
+		}
+
+		user.Password = "" // remove password hash
+		return &user, nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	user := models.User{
+		Name:       name,
+		Password:   hex.EncodeToString(secret),
+		Roles:      roles,
+		ExternalID: externalID,
+	}
+	duplicated, err := Create(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	// The name is taken by another user, disambiguate it
+	if duplicated {
+		user.Name = name + "#" + core.Sha256(externalID)[:8]
+		user.Password = hex.EncodeToString(secret)
+		if duplicated, err = Create(&user); err != nil {
+			return nil, err
+		}
+		if duplicated {
+			return nil, errors.New("Cannot provision the user " + name)
+		}
+	}
+
+	return &user, nil
+}
+
+// Revoke the user sessions and access tokens.
+func Revoke(userID string) error {
+	db := pg.DB()
+
+	_, err := db.Model(&models.Token{}).Where("user_id = ? AND type = 'refresh'", userID).Delete()
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Duration(viper.GetInt("token.ttl")) * time.Second
+	return redis.DB().RevokeUser(userID, ttl)
+}
+
 // genPassword hash password using bcrypt.
 func genPassword(password []byte) ([]byte, error) {
 	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
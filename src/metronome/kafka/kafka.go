@@ -39,6 +39,16 @@ func TopicStates() string {
 	return viper.GetString("kafka.topics.states")
 }
 
+// TopicCalendars kafka topic used for calendars
+func TopicCalendars() string {
+	return viper.GetString("kafka.topics.calendars")
+}
+
+// TopicAudit kafka topic used for audit events
+func TopicAudit() string {
+	return viper.GetString("kafka.topics.audit")
+}
+
 // GroupSchedulers kafka consumer group used for schedulers
 func GroupSchedulers() string {
 	return viper.GetString("kafka.groups.schedulers")
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/ovh/metronome/src/metronome/kafka"
+)
+
+// AuditEvent records a change made through the api.
+type AuditEvent struct {
+	ID string    `json:"id" sql:"id,pk"`
+	At time.Time `json:"at"`
+	// ActorID is the user who made the change, empty if anonymous
+	ActorID string `json:"actor_id"`
+	IP      string `json:"ip"`
+	// Action is the change kind, as resource.verb
+	Action string `json:"action"`
+	// Target identify the changed resource
+	Target string `json:"target"`
+	// Before and After hold the changed fields only
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+}
+
+// AuditEvents is an AuditEvent list
+type AuditEvents []AuditEvent
+
+// auditDiff is the Kafka representation of the audit event changes.
+type auditDiff struct {
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+}
+
+// ToKafka serialize an AuditEvent to Kafka.
+// Events are keyed by actor to keep their order.
+func (e *AuditEvent) ToKafka() *sarama.ProducerMessage {
+	dBytes, err := json.Marshal(auditDiff{e.Before, e.After})
+	if err != nil {
+		dBytes = []byte("{}")
+	}
+	d := base64.StdEncoding.EncodeToString(dBytes)
+
+	return &sarama.ProducerMessage{
+		Topic: kafka.TopicAudit(),
+		Key:   sarama.StringEncoder(e.ActorID),
+		Value: sarama.StringEncoder(fmt.Sprintf("%v %v %v %v %v %v %v", e.ID, e.ActorID, e.At.Unix(), url.QueryEscape(e.IP), url.QueryEscape(e.Action), url.QueryEscape(e.Target), d)),
+	}
+}
+
+// FromKafka unserialize an AuditEvent from Kafka.
+func (e *AuditEvent) FromKafka(msg *sarama.ConsumerMessage) error {
+	segs := strings.Split(string(msg.Value), " ")
+	if len(segs) != 7 {
+		return fmt.Errorf("unprocessable audit event(%v) - bad segments", string(msg.Key))
+	}
+
+	timestamp, err := strconv.ParseInt(segs[2], 0, 64)
+	if err != nil {
+		return fmt.Errorf("unprocessable audit event(%v) - bad timestamp", segs[0])
+	}
+
+	ip, err := url.QueryUnescape(segs[3])
+	if err != nil {
+		return fmt.Errorf("unprocessable audit event(%v) - bad ip", segs[0])
+	}
+
+	action, err := url.QueryUnescape(segs[4])
+	if err != nil {
+		return fmt.Errorf("unprocessable audit event(%v) - bad action", segs[0])
+	}
+
+	target, err := url.QueryUnescape(segs[5])
+	if err != nil {
+		return fmt.Errorf("unprocessable audit event(%v) - bad target", segs[0])
+	}
+
+	dBytes, err := base64.StdEncoding.DecodeString(segs[6])
+	if err != nil {
+		return fmt.Errorf("unprocessable audit event(%v) - bad diff (not base64)", segs[0])
+	}
+
+	var d auditDiff
+	if err := json.Unmarshal(dBytes, &d); err != nil {
+		return fmt.Errorf("unprocessable audit event(%v) - bad diff", segs[0])
+	}
+
+	e.ID = segs[0]
+	e.ActorID = segs[1]
+	e.At = time.Unix(timestamp, 0)
+	e.IP = ip
+	e.Action = action
+	e.Target = target
+	e.Before = d.Before
+	e.After = d.After
+
+	return nil
+}
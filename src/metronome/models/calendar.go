@@ -0,0 +1,217 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/ovh/metronome/src/metronome/core"
+	"github.com/ovh/metronome/src/metronome/kafka"
+)
+
+// Calendar holds scheduling exclusions shared by tasks.
+type Calendar struct {
+	GUID      string    `json:"guid" sql:"guid,pk"`
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	Timezone  string    `json:"timezone,omitempty"`
+	Dates     []string  `json:"dates" sql:",notnull"`
+	Windows   []Window  `json:"windows" sql:",notnull"`
+	CreatedAt time.Time `json:"created_at"`
+	// Removed is set on calendar deletion
+	Removed bool `json:"-" sql:"-"`
+}
+
+// Window is a weekly exclusion window.
+// Start and End are HH:MM times, a window ending before its start wrap over midnight.
+// Weekdays are english day names, an empty list match every day.
+type Window struct {
+	Weekdays []string `json:"weekdays,omitempty"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+}
+
+// Calendars is a Calendar list
+type Calendars []Calendar
+
+// calendarRules is the Kafka representation of the calendar exclusions.
+type calendarRules struct {
+	Timezone string   `json:"timezone,omitempty"`
+	Dates    []string `json:"dates"`
+	Windows  []Window `json:"windows"`
+}
+
+// CalendarGUID return the GUID of a user calendar.
+func CalendarGUID(userID, name string) string {
+	return core.Sha256(userID + "calendar" + name)
+}
+
+// ToKafka serialize a Calendar to Kafka.
+func (c *Calendar) ToKafka() *sarama.ProducerMessage {
+	if len(c.GUID) == 0 {
+		c.GUID = CalendarGUID(c.UserID, c.Name)
+	}
+
+	r := ""
+	if !c.Removed {
+		rBytes, err := json.Marshal(calendarRules{c.Timezone, c.Dates, c.Windows})
+		if err != nil {
+			rBytes = []byte("{}")
+		}
+		r = base64.StdEncoding.EncodeToString(rBytes)
+	}
+
+	return &sarama.ProducerMessage{
+		Topic: kafka.TopicCalendars(),
+		Key:   sarama.StringEncoder(c.GUID),
+		Value: sarama.StringEncoder(fmt.Sprintf("%v %v %v %v", c.UserID, url.QueryEscape(c.Name), c.CreatedAt.Unix(), r)),
+	}
+}
+
+// FromKafka unserialize a Calendar from Kafka.
+func (c *Calendar) FromKafka(msg *sarama.ConsumerMessage) error {
+	key := string(msg.Key)
+	segs := strings.Split(string(msg.Value), " ")
+	if len(segs) != 4 {
+		return fmt.Errorf("unprocessable calendar(%v) - bad segments", key)
+	}
+
+	name, err := url.QueryUnescape(segs[1])
+	if err != nil {
+		return fmt.Errorf("unprocessable calendar(%v) - bad name", key)
+	}
+
+	timestamp, err := strconv.ParseInt(segs[2], 0, 64)
+	if err != nil {
+		return fmt.Errorf("unprocessable calendar(%v) - bad timestamp", key)
+	}
+
+	c.GUID = key
+	c.UserID = segs[0]
+	c.Name = name
+	c.CreatedAt = time.Unix(timestamp, 0)
+
+	if len(segs[3]) == 0 {
+		c.Removed = true
+		return nil
+	}
+
+	rBytes, err := base64.StdEncoding.DecodeString(segs[3])
+	if err != nil {
+		return fmt.Errorf("unprocessable calendar(%v) - bad rules (not base64)", key)
+	}
+
+	var r calendarRules
+	if err := json.Unmarshal(rBytes, &r); err != nil {
+		return fmt.Errorf("unprocessable calendar(%v) - bad rules", key)
+	}
+	c.Timezone = r.Timezone
+	c.Dates = r.Dates
+	c.Windows = r.Windows
+
+	return nil
+}
+
+// ToJSON serialize a Calendar as JSON.
+func (c *Calendar) ToJSON() ([]byte, error) {
+	out, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Location return the calendar time location.
+// Default to UTC.
+func (c *Calendar) Location() *time.Location {
+	if len(c.Timezone) == 0 {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Excludes check if a time is excluded by the calendar.
+func (c *Calendar) Excludes(t time.Time) bool {
+	t = t.In(c.Location())
+
+	day := t.Format("2006-01-02")
+	for _, d := range c.Dates {
+		if d == day {
+			return true
+		}
+	}
+
+	minutes := t.Hour()*60 + t.Minute()
+	for _, w := range c.Windows {
+		if w.Includes(t.Weekday(), minutes) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Includes check if a weekday and a minute of the day are within the window.
+func (w *Window) Includes(weekday time.Weekday, minutes int) bool {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return false
+	}
+
+	if end <= start { // wrap over midnight, the window belong to its starting day
+		if minutes >= start {
+			return w.on(weekday)
+		}
+		return minutes < end && w.on((weekday+6)%7)
+	}
+	return minutes >= start && minutes < end && w.on(weekday)
+}
+
+// on check if the window apply to a weekday.
+func (w *Window) on(weekday time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+
+	for _, d := range w.Weekdays {
+		if strings.EqualFold(d, weekday.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock return the minute of the day of a HH:MM time.
+func parseClock(clock string) (int, error) {
+	segs := strings.Split(clock, ":")
+	if len(segs) != 2 {
+		return 0, fmt.Errorf("Bad clock %s", clock)
+	}
+
+	h, err := strconv.Atoi(segs[0])
+	if err != nil {
+		return 0, fmt.Errorf("Bad clock %s", clock)
+	}
+	m, err := strconv.Atoi(segs[1])
+	if err != nil {
+		return 0, fmt.Errorf("Bad clock %s", clock)
+	}
+
+	return h*60 + m, nil
+}
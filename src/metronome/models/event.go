@@ -0,0 +1,97 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Event types published to the users.
+const (
+	EventTaskCreated     = "task.created"
+	EventTaskUpdated     = "task.updated"
+	EventTaskDeleted     = "task.deleted"
+	EventCalendarUpdated = "calendar.updated"
+	EventCalendarDeleted = "calendar.deleted"
+	// eventExecution prefix the execution events, suffixed by the state kind
+	eventExecution = "execution."
+)
+
+// stateKinds are the state kinds by state.
+var stateKinds = map[int64]string{
+	Success:  "succeeded",
+	Failed:   "failed",
+	Expired:  "expired",
+	Excluded: "excluded",
+	Running:  "running",
+	Skipped:  "skipped",
+}
+
+// Event is a change notified to a user.
+type Event struct {
+	// ID is set by the event stream, empty until published
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	// Task is the task GUID the event relates to, if any
+	Task string          `json:"task,omitempty"`
+	At   int64           `json:"at"`
+	Data json.RawMessage `json:"data"`
+}
+
+// NewEvent return a new event of a task.
+func NewEvent(eventType, taskGUID string, data []byte) Event {
+	return Event{
+		Type: eventType,
+		Task: taskGUID,
+		At:   time.Now().Unix(),
+		Data: json.RawMessage(data),
+	}
+}
+
+// StateKind return the kind of a state, as used by the execution events.
+// Return an empty string for an unknown state.
+func StateKind(state int64) string {
+	return stateKinds[state]
+}
+
+// IsStateKind check if a state kind is known.
+func IsStateKind(kind string) bool {
+	for _, k := range stateKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ExecutionEvent return the event type of an execution state.
+func ExecutionEvent(state int64) string {
+	kind := StateKind(state)
+	if len(kind) == 0 {
+		kind = "unknown"
+	}
+	return eventExecution + kind
+}
+
+// Kind return the state kind of an execution event, empty for other events.
+func (e *Event) Kind() string {
+	if !strings.HasPrefix(e.Type, eventExecution) {
+		return ""
+	}
+	return strings.TrimPrefix(e.Type, eventExecution)
+}
+
+// ToJSON serialize an Event as JSON.
+func (e *Event) ToJSON() ([]byte, error) {
+	out, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// FromJSON unserialize an Event from JSON.
+func (e *Event) FromJSON(in []byte) error {
+	return json.Unmarshal(in, e)
+}
@@ -4,12 +4,14 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/Shopify/sarama"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/ovh/metronome/src/metronome/core"
 	"github.com/ovh/metronome/src/metronome/kafka"
 )
 
@@ -21,6 +23,57 @@ type Job struct {
 	Epsilon int64                  `json:"epsilon"`
 	URN     string                 `json:"URN"`
 	Payload map[string]interface{} `json:"payload"`
+	// Excluded by the task calendar or the user quota, the job must not be performed
+	Excluded bool `json:"excluded,omitempty"`
+	// Last execution of the task
+	Last bool `json:"last,omitempty"`
+	// TaskID is the ID of the task
+	TaskID string `json:"task_id,omitempty"`
+	// Upstream responses of a dependent task job
+	Upstream  *Response            `json:"upstream,omitempty"`
+	Upstreams map[string]*Response `json:"upstreams,omitempty"`
+	// Async jobs are completed by the target through the API
+	Async bool `json:"async,omitempty"`
+	// Deadline of async jobs completion in seconds, 0 if none
+	Deadline int64 `json:"deadline,omitempty"`
+	// Concurrency policy of the task
+	Concurrency string `json:"concurrency,omitempty"`
+	// Secrets of the task, sealed until rendered by the worker
+	Secrets string `json:"secrets,omitempty"`
+	// Capture the target response on the job state
+	Capture bool `json:"capture,omitempty"`
+	// Template render the URN and payload templates
+	Template bool `json:"template,omitempty"`
+	// ProjectID is the project of the task, if any
+	ProjectID string `json:"project_id,omitempty"`
+	// UpstreamID is the ID of the upstream job triggering a dependent task job,
+	// the runs triggered within the same second have distinct IDs
+	UpstreamID string `json:"upstream_id,omitempty"`
+}
+
+// jobUpstreams is the Kafka representation of the job upstream responses.
+type jobUpstreams struct {
+	Upstream  *Response            `json:"upstream,omitempty"`
+	Upstreams map[string]*Response `json:"upstreams,omitempty"`
+}
+
+// ID return the job ID.
+// It match the ID of the job state.
+func (j *Job) ID() string {
+	if len(j.UpstreamID) > 0 {
+		return core.Sha256(j.GUID + strconv.FormatInt(j.At, 10) + j.UpstreamID)
+	}
+	return core.Sha256(j.GUID + strconv.FormatInt(j.At, 10))
+}
+
+// Owner return the key scoping the job, its task project if any, else its user.
+func (j *Job) Owner() string {
+	return Owner(j.UserID, j.ProjectID)
+}
+
+// Locked return true if the job hold the task lock while performed.
+func (j *Job) Locked() bool {
+	return len(j.Concurrency) > 0 && j.Concurrency != ConcurrencyAllow
 }
 
 // ToKafka serialize a Job to Kafka.
@@ -32,10 +85,26 @@ func (j *Job) ToKafka() *sarama.ProducerMessage {
 	}
 	p := base64.StdEncoding.EncodeToString(payloadBytes)
 
+	u := ""
+	if j.Upstream != nil || len(j.Upstreams) > 0 {
+		uBytes, err := json.Marshal(jobUpstreams{j.Upstream, j.Upstreams})
+		if err != nil {
+			log.WithError(err).Warn("Cannot marshall job upstreams")
+		} else {
+			u = base64.StdEncoding.EncodeToString(uBytes)
+		}
+	}
+
+	// Template URNs may hold spaces
+	urn := j.URN
+	if j.Template {
+		urn = url.QueryEscape(j.URN)
+	}
+
 	return &sarama.ProducerMessage{
 		Topic: kafka.TopicJobs(),
 		Key:   sarama.StringEncoder(j.GUID),
-		Value: sarama.StringEncoder(fmt.Sprintf("%v %v %v %v %v %v", j.GUID, j.UserID, j.At, j.Epsilon, j.URN, p)),
+		Value: sarama.StringEncoder(fmt.Sprintf("%v %v %v %v %v %v %v %v %v %v %v %v %v %v %v %v %v %v", j.GUID, j.UserID, j.At, j.Epsilon, urn, p, j.Excluded, j.Last, url.QueryEscape(j.TaskID), u, j.Async, j.Deadline, j.Concurrency, j.Secrets, j.Capture, j.Template, j.ProjectID, j.UpstreamID)),
 	}
 }
 
@@ -43,7 +112,8 @@ func (j *Job) ToKafka() *sarama.ProducerMessage {
 func (j *Job) FromKafka(msg *sarama.ConsumerMessage) error {
 	key := string(msg.Key)
 	segs := strings.Split(string(msg.Value), " ")
-	if len(segs) != 6 {
+	// trailing segments are optional
+	if len(segs) < 6 {
 		return fmt.Errorf("unprocessable job(%v) - bad segments", key)
 	}
 
@@ -72,6 +142,85 @@ func (j *Job) FromKafka(msg *sarama.ConsumerMessage) error {
 	j.At = timestamp
 	j.Epsilon = epsilon
 	j.URN = segs[4]
+	if len(segs) > 6 {
+		excluded, err := strconv.ParseBool(segs[6])
+		if err != nil {
+			return fmt.Errorf("unprocessable job(%v) - bad excluded", key)
+		}
+		j.Excluded = excluded
+	}
+	if len(segs) > 7 {
+		last, err := strconv.ParseBool(segs[7])
+		if err != nil {
+			return fmt.Errorf("unprocessable job(%v) - bad last", key)
+		}
+		j.Last = last
+	}
+	if len(segs) > 9 {
+		taskID, err := url.QueryUnescape(segs[8])
+		if err != nil {
+			return fmt.Errorf("unprocessable job(%v) - bad task id", key)
+		}
+		j.TaskID = taskID
+
+		if len(segs[9]) > 0 {
+			uBytes, err := base64.StdEncoding.DecodeString(segs[9])
+			if err != nil {
+				return fmt.Errorf("unprocessable job(%v) - bad upstreams (not base64)", key)
+			}
+			var u jobUpstreams
+			if err := json.Unmarshal(uBytes, &u); err != nil {
+				return fmt.Errorf("unprocessable job(%v) - bad upstreams", key)
+			}
+			j.Upstream = u.Upstream
+			j.Upstreams = u.Upstreams
+		}
+	}
+	if len(segs) > 11 {
+		async, err := strconv.ParseBool(segs[10])
+		if err != nil {
+			return fmt.Errorf("unprocessable job(%v) - bad async", key)
+		}
+		deadline, err := strconv.ParseInt(segs[11], 0, 64)
+		if err != nil {
+			return fmt.Errorf("unprocessable job(%v) - bad deadline", key)
+		}
+		j.Async = async
+		j.Deadline = deadline
+	}
+	if len(segs) > 12 {
+		j.Concurrency = segs[12]
+	}
+	if len(segs) > 13 {
+		j.Secrets = segs[13]
+	}
+	if len(segs) > 14 {
+		capture, err := strconv.ParseBool(segs[14])
+		if err != nil {
+			return fmt.Errorf("unprocessable job(%v) - bad capture", key)
+		}
+		j.Capture = capture
+	}
+	if len(segs) > 15 {
+		template, err := strconv.ParseBool(segs[15])
+		if err != nil {
+			return fmt.Errorf("unprocessable job(%v) - bad template", key)
+		}
+		j.Template = template
+	}
+	if len(segs) > 16 {
+		j.ProjectID = segs[16]
+	}
+	if len(segs) > 17 {
+		j.UpstreamID = segs[17]
+	}
+	if j.Template {
+		urn, err := url.QueryUnescape(j.URN)
+		if err != nil {
+			return fmt.Errorf("unprocessable job(%v) - bad urn", key)
+		}
+		j.URN = urn
+	}
 
 	return nil
 }
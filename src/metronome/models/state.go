@@ -1,12 +1,14 @@
 package models
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/ovh/metronome/src/metronome/core"
 	"github.com/ovh/metronome/src/metronome/kafka"
@@ -19,6 +21,12 @@ const (
 	Failed
 	// Expired task not performed within epsilon time frame
 	Expired
+	// Excluded task not performed due to its calendar or its user quota
+	Excluded
+	// Running task accepted by its target, waiting for completion
+	Running
+	// Skipped task not performed as a previous execution is still running
+	Skipped
 )
 
 // State is a state of a task execution.
@@ -31,6 +39,19 @@ type State struct {
 	Duration int64  `json:"duration"`
 	URN      string `json:"URN"`
 	State    int64  `json:"state"`
+	// Last execution of the task
+	Last bool `json:"last,omitempty"`
+	// Response of the job endpoint, if any
+	Response *Response `json:"response,omitempty"`
+	// ProjectID is the project of the task, if any
+	ProjectID string `json:"projectID,omitempty"`
+}
+
+// Response is the HTTP response of a job execution.
+// Body holds the decoded JSON body, or the raw body if not JSON.
+type Response struct {
+	Status int         `json:"status"`
+	Body   interface{} `json:"body,omitempty"`
 }
 
 // States is a State array
@@ -41,10 +62,20 @@ func (s *State) ToKafka() *sarama.ProducerMessage {
 	if len(s.ID) == 0 {
 		s.ID = core.Sha256(s.TaskGUID + strconv.FormatInt(s.At, 10))
 	}
+	r := ""
+	if s.Response != nil {
+		rBytes, err := json.Marshal(s.Response)
+		if err != nil {
+			log.WithError(err).Warn("Cannot marshall state response")
+		} else {
+			r = base64.StdEncoding.EncodeToString(rBytes)
+		}
+	}
+
 	return &sarama.ProducerMessage{
 		Topic: kafka.TopicStates(),
 		Key:   sarama.StringEncoder(s.ID),
-		Value: sarama.StringEncoder(fmt.Sprintf("%v %v %v %v %v %v %v", s.TaskGUID, s.UserID, s.At, s.URN, s.DoneAt, s.Duration, s.State)),
+		Value: sarama.StringEncoder(fmt.Sprintf("%v %v %v %v %v %v %v %v %v %v", s.TaskGUID, s.UserID, s.At, s.URN, s.DoneAt, s.Duration, s.State, s.Last, r, s.ProjectID)),
 	}
 }
 
@@ -52,7 +83,8 @@ func (s *State) ToKafka() *sarama.ProducerMessage {
 func (s *State) FromKafka(msg *sarama.ConsumerMessage) error {
 	key := string(msg.Key)
 	segs := strings.Split(string(msg.Value), " ")
-	if len(segs) != 7 {
+	// trailing segments are optional
+	if len(segs) < 7 {
 		return fmt.Errorf("unprocessable state(%v) - bad segments", key)
 	}
 
@@ -84,6 +116,27 @@ func (s *State) FromKafka(msg *sarama.ConsumerMessage) error {
 	s.Duration = duration
 	s.URN = segs[3]
 	s.State = state
+	if len(segs) > 7 {
+		last, err := strconv.ParseBool(segs[7])
+		if err != nil {
+			return fmt.Errorf("unprocessable state(%v) - bad last", key)
+		}
+		s.Last = last
+	}
+	if len(segs) > 8 && len(segs[8]) > 0 {
+		rBytes, err := base64.StdEncoding.DecodeString(segs[8])
+		if err != nil {
+			return fmt.Errorf("unprocessable state(%v) - bad response (not base64)", key)
+		}
+		var r Response
+		if err := json.Unmarshal(rBytes, &r); err != nil {
+			return fmt.Errorf("unprocessable state(%v) - bad response", key)
+		}
+		s.Response = &r
+	}
+	if len(segs) > 9 {
+		s.ProjectID = segs[9]
+	}
 
 	return nil
 }
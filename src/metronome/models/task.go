@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -21,20 +22,83 @@ type Task struct {
 	GUID      string                 `json:"guid" sql:"guid,pk"`
 	ID        string                 `json:"id" sql:"id"`
 	UserID    string                 `json:"user_id"`
+	ProjectID string                 `json:"project_id,omitempty"`
 	Name      string                 `json:"name"`
 	Schedule  string                 `json:"schedule"`
 	URN       string                 `json:"URN"`
 	Payload   map[string]interface{} `json:"payload" sql:",notnull"`
+	Jitter    string                 `json:"jitter,omitempty"`
+	Spread    string                 `json:"spread,omitempty"`
+	Calendar  string                 `json:"calendar,omitempty"`
+	NotBefore *time.Time             `json:"not_before,omitempty"`
+	NotAfter  *time.Time             `json:"not_after,omitempty"`
+	After     []string               `json:"after,omitempty"`
+	Trigger   string                 `json:"trigger,omitempty"`
 	CreatedAt time.Time              `json:"created_at"`
+	// Completion mode, async jobs are completed by the target through the API
+	Completion string `json:"completion,omitempty"`
+	// Deadline of async jobs completion, renewed by heartbeats
+	Deadline string `json:"deadline,omitempty"`
+	// Concurrency policy of the task jobs
+	Concurrency string `json:"concurrency,omitempty"`
+	// CompletedAt is set by the aggregator once the task will not run anymore
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// Secrets are only accepted on creation, the api seal them
+	Secrets map[string]string `json:"secrets,omitempty" sql:"-"`
+	// SealedSecrets are only opened by the workers, never exposed.
+	// Empty on an update, the stored ones are kept, SecretsCleared clear them.
+	SealedSecrets string `json:"-" sql:"secrets"`
+	// CaptureResponse keep the target response on the job states, for the dependent tasks
+	CaptureResponse bool `json:"capture_response,omitempty"`
+	// Template render the URN and payload templates on each execution
+	Template bool `json:"template,omitempty"`
 }
 
+// SecretsCleared is sent as sealed secrets to clear the task secrets.
+const SecretsCleared = "-"
+
+const (
+	// SpreadHash offset executions by a stable hash of the task GUID
+	SpreadHash = "hash"
+	// SpreadRandom offset each execution randomly
+	SpreadRandom = "random"
+)
+
+const (
+	// TriggerSuccess run a dependent task when all its upstream tasks succeed
+	TriggerSuccess = "success"
+	// TriggerFailure run a dependent task when all its upstream tasks fail
+	TriggerFailure = "failure"
+	// TriggerAlways run a dependent task when all its upstream tasks are done
+	TriggerAlways = "always"
+)
+
+const (
+	// CompletionSync complete jobs with the target response
+	CompletionSync = "sync"
+	// CompletionAsync let the target complete jobs later on
+	CompletionAsync = "async"
+)
+
+const (
+	// ConcurrencyAllow run jobs even if a previous one is still running
+	ConcurrencyAllow = "allow"
+	// ConcurrencyForbid skip jobs while a previous one is running
+	ConcurrencyForbid = "forbid"
+	// ConcurrencyQueue delay jobs until the previous one is done
+	ConcurrencyQueue = "queue"
+	// ConcurrencyReplace fail the running job and run the new one
+	ConcurrencyReplace = "replace"
+)
+
 // Tasks is a Task list
 type Tasks []Task
 
 // ToKafka serialize a Task to Kafka.
+// Secrets must be sealed beforehand, only SealedSecrets are serialized.
 func (t *Task) ToKafka() *sarama.ProducerMessage {
 	if len(t.GUID) == 0 {
-		t.GUID = core.Sha256(t.UserID + t.ID)
+		t.GUID = TaskGUID(t.UserID, t.ProjectID, t.ID)
 	}
 
 	pBytes, err := json.Marshal(t.Payload)
@@ -44,18 +108,61 @@ func (t *Task) ToKafka() *sarama.ProducerMessage {
 	}
 	p := base64.StdEncoding.EncodeToString(pBytes)
 
+	// Template URNs may hold spaces
+	urn := t.URN
+	if t.Template {
+		urn = url.QueryEscape(t.URN)
+	}
+
+	after := make([]string, len(t.After))
+	for i, id := range t.After {
+		after[i] = url.QueryEscape(id)
+	}
+
 	return &sarama.ProducerMessage{
 		Topic: kafka.TopicTasks(),
 		Key:   sarama.StringEncoder(t.GUID),
-		Value: sarama.StringEncoder(fmt.Sprintf("%v %v %v %v %v %v %v", t.UserID, t.ID, t.Schedule, t.URN, url.QueryEscape(t.Name), t.CreatedAt.Unix(), p)),
+		Value: sarama.StringEncoder(fmt.Sprintf("%v %v %v %v %v %v %v %v %v %v %v %v %v %v %v %v %v %v %v %v %v", t.UserID, t.ID, t.Schedule, urn, url.QueryEscape(t.Name), t.CreatedAt.Unix(), p, t.Jitter, t.Spread, url.QueryEscape(t.Calendar), unixOrZero(t.NotBefore), unixOrZero(t.NotAfter), strings.Join(after, ","), t.Trigger, t.Completion, t.Deadline, t.Concurrency, t.ProjectID, t.SealedSecrets, t.CaptureResponse, t.Template)),
+	}
+}
+
+// TaskGUID return the GUID of a task.
+// Project tasks are shared by the project members.
+func TaskGUID(userID, projectID, id string) string {
+	if len(projectID) > 0 {
+		return core.Sha256(projectID + id)
+	}
+	return core.Sha256(userID + id)
+}
+
+// Owner return the key scoping the jobs of a task, its project if any, else its user.
+func Owner(userID, projectID string) string {
+	if len(projectID) > 0 {
+		return "project:" + projectID
+	}
+	return userID
+}
+
+// TaskTombstone return a Kafka tombstone removing a task from the compacted topic.
+func TaskTombstone(guid string) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic: kafka.TopicTasks(),
+		Key:   sarama.StringEncoder(guid),
 	}
 }
 
 // FromKafka unserialize a Task from Kafka.
+// A tombstone is unserialized as a task deletion.
 func (t *Task) FromKafka(msg *sarama.ConsumerMessage) error {
 	key := string(msg.Key)
+	if msg.Value == nil {
+		t.GUID = key
+		return nil
+	}
+
 	segs := strings.Split(string(msg.Value), " ")
-	if len(segs) != 7 {
+	// trailing segments are optional
+	if len(segs) < 7 {
 		log.Infof("segments: %+v %+v", segs, len(segs))
 		return fmt.Errorf("unprocessable task(%v) - bad segments", key)
 	}
@@ -86,10 +193,152 @@ func (t *Task) FromKafka(msg *sarama.ConsumerMessage) error {
 	t.URN = segs[3]
 	t.Name = name
 	t.CreatedAt = time.Unix(int64(timestamp), 0)
+	if len(segs) > 8 {
+		t.Jitter = segs[7]
+		t.Spread = segs[8]
+	}
+	if len(segs) > 9 {
+		calendar, err := url.QueryUnescape(segs[9])
+		if err != nil {
+			return fmt.Errorf("unprocessable task(%v) - bad calendar", key)
+		}
+		t.Calendar = calendar
+	}
+	if len(segs) > 11 {
+		notBefore, err := timeOrNil(segs[10])
+		if err != nil {
+			return fmt.Errorf("unprocessable task(%v) - bad not before", key)
+		}
+		notAfter, err := timeOrNil(segs[11])
+		if err != nil {
+			return fmt.Errorf("unprocessable task(%v) - bad not after", key)
+		}
+		t.NotBefore = notBefore
+		t.NotAfter = notAfter
+	}
+	if len(segs) > 13 {
+		if len(segs[12]) > 0 {
+			for _, id := range strings.Split(segs[12], ",") {
+				after, err := url.QueryUnescape(id)
+				if err != nil {
+					return fmt.Errorf("unprocessable task(%v) - bad after", key)
+				}
+				t.After = append(t.After, after)
+			}
+		}
+		t.Trigger = segs[13]
+	}
+	if len(segs) > 15 {
+		t.Completion = segs[14]
+		t.Deadline = segs[15]
+	}
+	if len(segs) > 16 {
+		t.Concurrency = segs[16]
+	}
+	if len(segs) > 17 {
+		t.ProjectID = segs[17]
+	}
+	if len(segs) > 18 {
+		t.SealedSecrets = segs[18]
+	}
+	if len(segs) > 19 {
+		capture, err := strconv.ParseBool(segs[19])
+		if err != nil {
+			return fmt.Errorf("unprocessable task(%v) - bad capture response", key)
+		}
+		t.CaptureResponse = capture
+	}
+	if len(segs) > 20 {
+		template, err := strconv.ParseBool(segs[20])
+		if err != nil {
+			return fmt.Errorf("unprocessable task(%v) - bad template", key)
+		}
+		t.Template = template
+	}
+	if t.Template {
+		urn, err := url.QueryUnescape(t.URN)
+		if err != nil {
+			return fmt.Errorf("unprocessable task(%v) - bad urn", key)
+		}
+		t.URN = urn
+	}
 
 	return nil
 }
 
+// Deleted check if the task is a deletion.
+func (t *Task) Deleted() bool {
+	return len(t.URN) == 0
+}
+
+// Scheduled check if the task run on its own schedule.
+// Unscheduled tasks are run after their upstream tasks.
+func (t *Task) Scheduled() bool {
+	return len(t.Schedule) > 0
+}
+
+var timeRegex = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+
+// DeadlineSeconds return the async completion deadline in seconds, 0 if none.
+func (t *Task) DeadlineSeconds() int64 {
+	return timeSeconds(t.Deadline)
+}
+
+// JitterSeconds return the jitter window in seconds, 0 if none.
+func (t *Task) JitterSeconds() int64 {
+	return timeSeconds(t.Jitter)
+}
+
+// timeSeconds return the seconds of a PT duration, 0 if invalid.
+func timeSeconds(duration string) int64 {
+	matches := timeRegex.FindStringSubmatch(duration)
+	if matches == nil {
+		return 0
+	}
+
+	var seconds int64
+	for i, unit := range []int64{3600, 60, 1} {
+		if len(matches[i+1]) == 0 {
+			continue
+		}
+		v, err := strconv.ParseInt(matches[i+1], 10, 64)
+		if err != nil {
+			return 0
+		}
+		seconds += v * unit
+	}
+	return seconds
+}
+
+var periodRegex = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// PeriodSeconds return the schedule period in seconds, 0 if unscheduled or invalid.
+// Years and months are approximated as the scheduler does.
+func (t *Task) PeriodSeconds() int64 {
+	segs := strings.Split(t.Schedule, "/")
+	if len(segs) != 4 {
+		return 0
+	}
+
+	matches := periodRegex.FindStringSubmatch(segs[2])
+	if matches == nil {
+		return 0
+	}
+
+	var seconds int64
+	for i, unit := range []int64{365 * 86400, 30 * 86400, 86400, 3600, 60, 1} {
+		if len(matches[i+1]) == 0 {
+			continue
+		}
+		v, err := strconv.ParseInt(matches[i+1], 10, 64)
+		if err != nil {
+			return 0
+		}
+		seconds += v * unit
+	}
+	return seconds
+}
+
 // ToJSON serialize a Task as JSON.
 func (t *Task) ToJSON() ([]byte, error) {
 	out, err := json.Marshal(t)
@@ -99,3 +348,24 @@ func (t *Task) ToJSON() ([]byte, error) {
 
 	return out, nil
 }
+
+// unixOrZero return the unix timestamp of a time, 0 if unset.
+func unixOrZero(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// timeOrNil parse a unix timestamp, 0 is unset.
+func timeOrNil(s string) (*time.Time, error) {
+	timestamp, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return nil, err
+	}
+	if timestamp == 0 {
+		return nil, nil
+	}
+	t := time.Unix(timestamp, 0)
+	return &t, nil
+}
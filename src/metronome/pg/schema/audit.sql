@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS audit_events
+(
+    id uuid NOT NULL,
+    at timestamp without time zone NOT NULL,
+    actor_id uuid,
+    ip text,
+    action character varying(256) NOT NULL,
+    target text,
+    before jsonb,
+    after jsonb,
+    CONSTRAINT audit_events_pkey PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_at_idx
+    ON audit_events USING btree
+    (at)
+    TABLESPACE pg_default;
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx
+    ON audit_events USING btree
+    (actor_id)
+    TABLESPACE pg_default;
+
+-- Audit events are append-only
+CREATE OR REPLACE RULE audit_events_no_update AS ON UPDATE TO audit_events DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE audit_events_no_delete AS ON DELETE TO audit_events DO INSTEAD NOTHING;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS calendars
+(
+    guid text NOT NULL,
+    user_id uuid NOT NULL,
+    name text NOT NULL,
+    timezone text,
+    dates jsonb NOT NULL,
+    windows jsonb NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    CONSTRAINT calendars_pkey PRIMARY KEY (guid),
+    CONSTRAINT user_id_fk FOREIGN KEY (user_id)
+        REFERENCES users (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION
+);
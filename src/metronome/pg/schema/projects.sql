@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS projects
+(
+    project_id uuid NOT NULL DEFAULT uuid_generate_v4(),
+    name character varying(256) NOT NULL,
+    created_at timestamp without time zone NOT NULL DEFAULT now(),
+    CONSTRAINT projects_pkey PRIMARY KEY (project_id)
+);
+
+CREATE TABLE IF NOT EXISTS members
+(
+    project_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    role character varying(32) NOT NULL,
+    created_at timestamp without time zone NOT NULL DEFAULT now(),
+    CONSTRAINT members_pkey PRIMARY KEY (project_id, user_id),
+    CONSTRAINT project_id_fk FOREIGN KEY (project_id)
+        REFERENCES projects (project_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE CASCADE,
+    CONSTRAINT user_id_fk FOREIGN KEY (user_id)
+        REFERENCES users (user_id) MATCH SIMPLE
+        ON UPDATE NO ACTION
+        ON DELETE NO ACTION
+);
+
+CREATE INDEX IF NOT EXISTS members_user_id_idx
+    ON members USING btree
+    (user_id)
+    TABLESPACE pg_default;
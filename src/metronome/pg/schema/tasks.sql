@@ -2,10 +2,25 @@ CREATE TABLE IF NOT EXISTS tasks
 (
     guid text NOT NULL,
     user_id uuid NOT NULL,
+    project_id uuid,
     name text NOT NULL,
     urn text NOT NULL,
-    schedule text NOT NULL,
+    schedule text,
     payload jsonb,
+    jitter text,
+    spread text,
+    calendar text,
+    not_before timestamp without time zone,
+    not_after timestamp without time zone,
+    completed_at timestamp without time zone,
+    after jsonb,
+    trigger text,
+    completion text,
+    deadline text,
+    concurrency text,
+    secrets text,
+    capture_response boolean NOT NULL DEFAULT false,
+    template boolean NOT NULL DEFAULT false,
     created_at timestamp without time zone NOT NULL,
     id text NOT NULL,
     CONSTRAINT tasks_pkey PRIMARY KEY (guid),
@@ -13,4 +28,26 @@ CREATE TABLE IF NOT EXISTS tasks
         REFERENCES users (user_id) MATCH SIMPLE
         ON UPDATE NO ACTION
         ON DELETE NO ACTION
-)
+);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS jitter text;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS spread text;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS calendar text;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS not_before timestamp without time zone;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS not_after timestamp without time zone;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS completed_at timestamp without time zone;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS after jsonb;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS trigger text;
+ALTER TABLE tasks ALTER COLUMN schedule DROP NOT NULL;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS completion text;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS deadline text;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS concurrency text;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS project_id uuid;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS secrets text;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS capture_response boolean NOT NULL DEFAULT false;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS template boolean NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS tasks_project_id_idx
+    ON tasks USING btree
+    (project_id)
+    TABLESPACE pg_default;
@@ -5,6 +5,12 @@ CREATE TABLE IF NOT EXISTS tokens
     type character varying(256) NOT NULL,
     created_at timestamp without time zone NOT NULL DEFAULT now(),
     roles jsonb,
+    id text,
+    name character varying(256),
+    last_used_at timestamp without time zone,
+    family text,
+    expires_at timestamp without time zone,
+    used_at timestamp without time zone,
     CONSTRAINT tokens_pkey PRIMARY KEY (token),
     CONSTRAINT user_id_fk FOREIGN KEY (user_id)
         REFERENCES users (user_id) MATCH SIMPLE
@@ -15,3 +21,23 @@ CREATE UNIQUE INDEX IF NOT EXISTS tokens_token_idx
     ON tokens USING btree
     (token)
     TABLESPACE pg_default;
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS id text;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS name character varying(256);
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_at timestamp without time zone;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family text;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS expires_at timestamp without time zone;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at timestamp without time zone;
+
+-- Refresh tokens stored in plaintext never expire, revoke them
+DELETE FROM tokens WHERE type = 'refresh' AND expires_at IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS tokens_id_idx
+    ON tokens USING btree
+    (id)
+    TABLESPACE pg_default;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx
+    ON tokens USING btree
+    (family)
+    TABLESPACE pg_default;
@@ -5,6 +5,9 @@ CREATE TABLE IF NOT EXISTS users
     password character varying(256) NOT NULL,
     created_at timestamp without time zone NOT NULL DEFAULT now(),
     roles jsonb,
+    owner_id uuid,
+    external_id text,
+    disabled_at timestamp without time zone,
     CONSTRAINT users_pkey PRIMARY KEY (user_id)
 );
 
@@ -12,3 +15,12 @@ CREATE UNIQUE INDEX IF NOT EXISTS users_name_idx
     ON users USING btree
     (name)
     TABLESPACE pg_default;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS owner_id uuid;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id text;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at timestamp without time zone;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_external_id_idx
+    ON users USING btree
+    (external_id)
+    TABLESPACE pg_default;
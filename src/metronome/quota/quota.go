@@ -0,0 +1,162 @@
+// Package quota defined the per user limits shared by the api and the scheduler.
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/viper"
+
+	"github.com/ovh/metronome/src/metronome/models"
+)
+
+// Quota holds the per user limits, 0 is unlimited.
+type Quota struct {
+	// Tasks is the maximum number of active tasks
+	Tasks int
+	// Period is the minimum schedule period in seconds
+	Period int64
+	// Executions is the maximum number of executions per minute
+	Executions int
+	// Payload is the maximum payload size in bytes
+	Payload int
+}
+
+// Exceeded is returned when a task exceed a quota.
+type Exceeded struct {
+	// Field of the task which exceed the quota
+	Field       string
+	Description string
+}
+
+func (e *Exceeded) Error() string {
+	return e.Description
+}
+
+// Get return the quotas from quota.tasks, quota.period, quota.executions and quota.payload.
+func Get() Quota {
+	return Quota{
+		Tasks:      viper.GetInt("quota.tasks"),
+		Period:     viper.GetInt64("quota.period"),
+		Executions: viper.GetInt("quota.executions"),
+		Payload:    viper.GetInt("quota.payload"),
+	}
+}
+
+// Check the limits of a single task: its period and payload size.
+// Return an *Exceeded error if a limit is exceeded.
+func (q Quota) Check(t *models.Task) error {
+	if q.Period > 0 && t.Scheduled() {
+		if period := t.PeriodSeconds(); period > 0 && period < q.Period {
+			return &Exceeded{
+				Field:       "schedule",
+				Description: fmt.Sprintf("schedule period must be at least %d seconds", q.Period),
+			}
+		}
+	}
+
+	if q.Payload > 0 {
+		size, err := PayloadSize(t)
+		if err != nil {
+			return err
+		}
+		if size > q.Payload {
+			return &Exceeded{
+				Field:       "payload",
+				Description: fmt.Sprintf("payload must not exceed %d bytes", q.Payload),
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckUsage check the limits of a user owning tasks, the new one included.
+// Return an *Exceeded error if a limit is exceeded.
+func (q Quota) CheckUsage(tasks models.Tasks) error {
+	if q.Tasks > 0 && len(tasks) > q.Tasks {
+		return &Exceeded{
+			Field:       "id",
+			Description: fmt.Sprintf("a user can not own more than %d tasks", q.Tasks),
+		}
+	}
+
+	if q.Executions > 0 {
+		var rate float64
+		for _, r := range Rates(tasks) {
+			rate += r
+		}
+		if rate > float64(q.Executions) {
+			return &Exceeded{
+				Field:       "schedule",
+				Description: fmt.Sprintf("a user can not run more than %d executions per minute", q.Executions),
+			}
+		}
+	}
+
+	return nil
+}
+
+// Rates return the executions per minute of tasks by GUID.
+// A dependent task run at most once per run of its slowest upstream task,
+// upstream tasks missing from tasks are not accounted.
+func Rates(tasks models.Tasks) map[string]float64 {
+	byGUID := make(map[string]*models.Task, len(tasks))
+	for i := range tasks {
+		byGUID[models.TaskGUID(tasks[i].UserID, tasks[i].ProjectID, tasks[i].ID)] = &tasks[i]
+	}
+
+	rates := make(map[string]float64, len(tasks))
+	var rate func(t *models.Task) float64
+	rate = func(t *models.Task) float64 {
+		guid := models.TaskGUID(t.UserID, t.ProjectID, t.ID)
+		if r, ok := rates[guid]; ok {
+			return r
+		}
+		rates[guid] = 0 // break dependency cycles
+
+		r := Rate(t)
+		if !t.Scheduled() && len(t.After) > 0 {
+			r = -1
+			// Upstream tasks share the dependent task scope
+			for _, id := range t.After {
+				u, ok := byGUID[models.TaskGUID(t.UserID, t.ProjectID, id)]
+				if !ok {
+					continue
+				}
+				if ur := rate(u); r < 0 || ur < r {
+					r = ur
+				}
+			}
+			if r < 0 {
+				r = 0
+			}
+		}
+
+		rates[guid] = r
+		return r
+	}
+
+	for i := range tasks {
+		rate(&tasks[i])
+	}
+	return rates
+}
+
+// Rate return the executions per minute of a scheduled task, 0 for dependent tasks.
+func Rate(t *models.Task) float64 {
+	period := t.PeriodSeconds()
+	if !t.Scheduled() || period == 0 {
+		return 0
+	}
+	return 60 / float64(period)
+}
+
+// PayloadSize return the size of a task payload in bytes, as serialized.
+func PayloadSize(t *models.Task) (int, error) {
+	out, err := json.Marshal(t.Payload)
+	if err != nil {
+		return 0, err
+	}
+	return len(out), nil
+}
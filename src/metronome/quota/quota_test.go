@@ -0,0 +1,99 @@
+package quota_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/quota"
+)
+
+const (
+	everySecond = "R/2017-01-01T00:00:00Z/PT1S/ET1S"
+	everyMinute = "R/2017-01-01T00:00:00Z/PT1M/ET1S"
+)
+
+func task(projectID, id, schedule string, after ...string) models.Task {
+	return models.Task{
+		ID:        id,
+		UserID:    "user",
+		ProjectID: projectID,
+		Schedule:  schedule,
+		After:     after,
+	}
+}
+
+var _ = Describe("Rates", func() {
+	It("should rate each scheduled task", func() {
+		rates := quota.Rates(models.Tasks{
+			task("", "a", everySecond),
+			task("", "b", everyMinute),
+		})
+
+		Ω(rates).Should(HaveLen(2))
+		Ω(rates[models.TaskGUID("user", "", "a")]).Should(Equal(60.0))
+		Ω(rates[models.TaskGUID("user", "", "b")]).Should(Equal(1.0))
+	})
+
+	It("should rate dependent tasks as their slowest upstream task", func() {
+		rates := quota.Rates(models.Tasks{
+			task("", "c", "", "b"),
+			task("", "a", everySecond),
+			task("", "b", "", "a"),
+			task("", "d", "", "a", "e"),
+			task("", "e", everyMinute),
+		})
+
+		Ω(rates[models.TaskGUID("user", "", "b")]).Should(Equal(60.0))
+		Ω(rates[models.TaskGUID("user", "", "c")]).Should(Equal(60.0))
+		Ω(rates[models.TaskGUID("user", "", "d")]).Should(Equal(1.0))
+	})
+
+	It("should not merge tasks of different scopes", func() {
+		rates := quota.Rates(models.Tasks{
+			task("", "a", everySecond),
+			task("project", "a", everyMinute),
+			task("project", "b", "", "a"),
+		})
+
+		Ω(rates).Should(HaveLen(3))
+		Ω(rates[models.TaskGUID("user", "project", "b")]).Should(Equal(1.0))
+	})
+
+	It("should stop on dependency cycles", func() {
+		rates := quota.Rates(models.Tasks{
+			task("", "a", "", "b"),
+			task("", "b", "", "a"),
+		})
+
+		Ω(rates[models.TaskGUID("user", "", "a")]).Should(Equal(0.0))
+		Ω(rates[models.TaskGUID("user", "", "b")]).Should(Equal(0.0))
+	})
+})
+
+var _ = Describe("CheckUsage", func() {
+	q := quota.Quota{Executions: 120}
+
+	It("should sum the executions of all the tasks", func() {
+		Ω(q.CheckUsage(models.Tasks{
+			task("", "a", everySecond),
+			task("", "b", everySecond),
+		})).Should(Succeed())
+
+		Ω(q.CheckUsage(models.Tasks{
+			task("", "a", everySecond),
+			task("", "b", everySecond),
+			task("", "c", everyMinute),
+		})).Should(HaveOccurred())
+	})
+
+	It("should account the dependent tasks", func() {
+		err := q.CheckUsage(models.Tasks{
+			task("", "a", everySecond),
+			task("", "b", "", "a"),
+			task("", "c", "", "b"),
+		})
+		Ω(err).Should(HaveOccurred())
+		Ω(err.(*quota.Exceeded).Field).Should(Equal("schedule"))
+	})
+})
@@ -0,0 +1,54 @@
+package redis
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"gopkg.in/redis.v5"
+
+	"github.com/ovh/metronome/src/metronome/models"
+)
+
+// delayedKey hold the delayed jobs by retry time.
+const delayedKey = "delayed:jobs"
+
+// Delay park a job until a retry time.
+func (c *Client) Delay(j models.Job, at time.Time) error {
+	out, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	return c.ZAdd(delayedKey, redis.Z{Score: float64(at.Unix()), Member: string(out)}).Err()
+}
+
+// Due remove and return the delayed jobs to retry.
+func (c *Client) Due(now time.Time) ([]models.Job, error) {
+	members, err := c.ZRangeByScore(delayedKey, redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []models.Job
+	for _, m := range members {
+		removed, err := c.ZRem(delayedKey, m).Result()
+		if err != nil {
+			return nil, err
+		}
+		if removed == 0 { // retried by another worker
+			continue
+		}
+
+		var j models.Job
+		if err := json.Unmarshal([]byte(m), &j); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,131 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/ovh/metronome/src/metronome/models"
+)
+
+// appendEventScript append an event to a stream holding about ARGV[1] events,
+// expired after ARGV[2] seconds without event.
+// Return the event id.
+const appendEventScript = `local id = redis.call("xadd", KEYS[1], "maxlen", "~", ARGV[1], "*", "event", ARGV[3])
+redis.call("expire", KEYS[1], ARGV[2])
+return id`
+
+func eventsKey(userID string) string {
+	return "events:" + userID
+}
+
+// PublishEvent send an event to a user.
+// The event is kept in the user stream, for replay, then sent to the user channel.
+// Streams require redis 5, on older servers the event is sent without id nor replay.
+func (c *Client) PublishEvent(userID string, e models.Event) error {
+	body, err := e.ToJSON()
+	if err != nil {
+		return err
+	}
+
+	size := viper.GetInt64("events.replay.size")
+	ttl := viper.GetInt64("events.replay.ttl")
+	id, err := c.Eval(appendEventScript, []string{eventsKey(userID)}, size, ttl, string(body)).Result()
+	if err != nil {
+		log.WithError(err).Warn("Could not append the event to the stream, is redis older than 5?")
+		return c.Publish(userID, string(body)).Err()
+	}
+
+	e.ID, _ = id.(string)
+	body, err = e.ToJSON()
+	if err != nil {
+		return err
+	}
+
+	return c.Publish(userID, string(body)).Err()
+}
+
+// Events return the events of a user stream after an event id.
+// Return false if the event id is unknown or no longer in the stream, or if the stream expired,
+// some events are then missing.
+func (c *Client) Events(userID, after string) ([]models.Event, bool, error) {
+	if _, _, ok := splitEventID(after); !ok {
+		res, err := c.events(eventsKey(userID), "-")
+		return res, false, err
+	}
+
+	res, err := c.events(eventsKey(userID), after)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The range include the event id while in the stream,
+	// an empty range mean the stream expired, or never held it
+	found := len(res) > 0 && res[0].ID == after
+	if found {
+		res = res[1:]
+	}
+	return res, found, nil
+}
+
+// events return the events of a stream from an event id.
+func (c *Client) events(key, from string) ([]models.Event, error) {
+	res, err := c.Eval(`return redis.call("xrange", KEYS[1], ARGV[1], "+")`, []string{key}, from).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, _ := res.([]interface{})
+	events := make([]models.Event, 0, len(entries))
+	for _, entry := range entries {
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) < 2 {
+			continue
+		}
+		id, _ := fields[0].(string)
+		values, _ := fields[1].([]interface{})
+		if len(values) < 2 {
+			continue
+		}
+		body, _ := values[1].(string)
+
+		var e models.Event
+		if err := e.FromJSON([]byte(body)); err != nil {
+			continue
+		}
+		e.ID = id
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// EventAfter check if an event id follows another one in a stream.
+// An empty last id precede every event.
+func EventAfter(id, last string) bool {
+	if len(last) == 0 {
+		return true
+	}
+	ms, seq, _ := splitEventID(id)
+	lastMs, lastSeq, _ := splitEventID(last)
+	return ms > lastMs || (ms == lastMs && seq > lastSeq)
+}
+
+// splitEventID return the time and sequence parts of a stream id.
+// Return false if the id is malformed.
+func splitEventID(id string) (uint64, uint64, bool) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return ms, seq, true
+}
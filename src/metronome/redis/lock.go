@@ -0,0 +1,42 @@
+package redis
+
+import (
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// unlockScript delete a lock only if owned by the given holder.
+const unlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`
+
+func lockKey(guid string) string {
+	return "lock:" + guid
+}
+
+// Lock take the task lock for a job.
+// Return false if the lock is already held.
+func (c *Client) Lock(guid, id string, ttl time.Duration) (bool, error) {
+	return c.SetNX(lockKey(guid), id, ttl).Result()
+}
+
+// ForceLock take the task lock for a job, even if already held.
+// Return the previous holder, empty if none.
+func (c *Client) ForceLock(guid, id string, ttl time.Duration) (string, error) {
+	previous, err := c.GetSet(lockKey(guid), id).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+
+	if err := c.Expire(lockKey(guid), ttl).Err(); err != nil {
+		return "", err
+	}
+	return previous, nil
+}
+
+// Unlock release the task lock if held by the job.
+func (c *Client) Unlock(guid, id string) error {
+	return c.Eval(unlockScript, []string{lockKey(guid)}, id).Err()
+}
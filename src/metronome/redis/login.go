@@ -0,0 +1,49 @@
+package redis
+
+import (
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+func loginFailuresKey(username, ip string) string {
+	return "login:failures:" + username + ":" + ip
+}
+
+func loginLockKey(username, ip string) string {
+	return "login:lock:" + username + ":" + ip
+}
+
+// LoginLocked return how long the logins of a user from an IP are locked, 0 if not locked.
+func (c *Client) LoginLocked(username, ip string) (time.Duration, error) {
+	ttl, err := c.PTTL(loginLockKey(username, ip)).Result()
+	if err == redis.Nil || ttl < 0 {
+		return 0, nil
+	}
+	return ttl, err
+}
+
+// LoginFailed account a failed login of a user from an IP.
+// Failures are forgotten after ttl without failure.
+// Return the failures count.
+func (c *Client) LoginFailed(username, ip string, ttl time.Duration) (int64, error) {
+	failures, err := c.Incr(loginFailuresKey(username, ip)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.Expire(loginFailuresKey(username, ip), ttl).Err(); err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+// LockLogin lock the logins of a user from an IP for a while.
+func (c *Client) LockLogin(username, ip string, lock time.Duration) error {
+	return c.Set(loginLockKey(username, ip), 1, lock).Err()
+}
+
+// LoginSucceeded forget the failed logins of a user from an IP.
+func (c *Client) LoginSucceeded(username, ip string) error {
+	return c.Del(loginFailuresKey(username, ip), loginLockKey(username, ip)).Err()
+}
@@ -0,0 +1,381 @@
+package redis
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/redis.v5"
+
+	"github.com/ovh/metronome/src/metronome/models"
+)
+
+// QueuedJob is a job held in a pull queue.
+type QueuedJob struct {
+	ID         string     `json:"id"`
+	Queue      string     `json:"queue"`
+	Job        models.Job `json:"job"`
+	Deliveries int        `json:"deliveries"`
+	PulledAt   int64      `json:"pulledAt,omitempty"`
+}
+
+// queuesKey index the pull queues, as "owner queue".
+const queuesKey = "queues"
+
+// expiriesKey index the jobs not delivered yet by expiry time, as owner:id.
+const expiriesKey = "queued:expiries"
+
+// signalTTL bound the lifetime of the signals no dequeue waited for, in seconds.
+const signalTTL = 60
+
+// enqueueScript store a job, push it in its queue, then signal the waiting dequeues.
+// KEYS: jobs hash, queue list, queues set, expiries zset, signal list.
+// ARGV: job id, job, queues member, expiries member, expiry, signal ttl.
+const enqueueScript = `redis.call("hset", KEYS[1], ARGV[1], ARGV[2])
+redis.call("lpush", KEYS[2], ARGV[1])
+redis.call("sadd", KEYS[3], ARGV[3])
+if tonumber(ARGV[5]) > 0 then
+	redis.call("zadd", KEYS[4], ARGV[5], ARGV[4])
+end
+redis.call("lpush", KEYS[5], 1)
+redis.call("expire", KEYS[5], ARGV[6])
+return 1`
+
+// popScript pop a job from its queue and mark it in flight until ARGV[1].
+// KEYS: queue list, in flight zset.
+const popScript = `local id = redis.call("rpop", KEYS[1])
+if id then
+	redis.call("zadd", KEYS[2], ARGV[1], id)
+end
+return id`
+
+// redeliverScript push back an in flight job, unless settled or requeued meanwhile.
+// KEYS: in flight zset, queue list, signal list.
+// ARGV: job id, signal ttl.
+const redeliverScript = `if redis.call("zrem", KEYS[1], ARGV[1]) == 1 then
+	redis.call("rpush", KEYS[2], ARGV[1])
+	redis.call("lpush", KEYS[3], 1)
+	redis.call("expire", KEYS[3], ARGV[2])
+	return 1
+end
+return 0`
+
+// forgetScript unindex an empty queue.
+// KEYS: queue list, in flight zset, queues set.
+const forgetScript = `if redis.call("llen", KEYS[1]) == 0 and redis.call("zcard", KEYS[2]) == 0 then
+	return redis.call("srem", KEYS[3], ARGV[1])
+end
+return 0`
+
+func queueKey(owner, queue string) string {
+	return "queue:" + owner + ":" + queue
+}
+
+func inflightKey(owner, queue string) string {
+	return "inflight:" + owner + ":" + queue
+}
+
+// signalKey hold a token per job pushed in a queue, popped by the waiting dequeues.
+func signalKey(owner, queue string) string {
+	return "signal:" + owner + ":" + queue
+}
+
+func queuedJobsKey(owner string) string {
+	return "queued:" + owner
+}
+
+// splitMember split an owner:id member.
+// Owners may hold colons, as project owners, ids do not.
+func splitMember(m string) (string, string, bool) {
+	i := strings.LastIndex(m, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	return m[:i], m[i+1:], true
+}
+
+// Enqueue a job in a user pull queue.
+// The job expire if not pulled within its epsilon.
+func (c *Client) Enqueue(queue string, j models.Job) error {
+	qj := QueuedJob{
+		ID:    j.ID(),
+		Queue: queue,
+		Job:   j,
+	}
+
+	out, err := json.Marshal(qj)
+	if err != nil {
+		return err
+	}
+
+	var expiry int64
+	if j.Epsilon > 0 {
+		expiry = j.At + j.Epsilon
+	}
+
+	keys := []string{queuedJobsKey(j.Owner()), queueKey(j.Owner(), queue), queuesKey, expiriesKey, signalKey(j.Owner(), queue)}
+	return c.Eval(enqueueScript, keys, qj.ID, string(out), j.Owner()+" "+queue, j.Owner()+":"+qj.ID, expiry, signalTTL).Err()
+}
+
+// Dequeue pull up to max jobs from a user queue.
+// Jobs are hidden for the visibility duration, then redelivered unless settled,
+// up to maxDeliveries times. Jobs not pulled within their epsilon are left to Stale.
+// If the queue is empty, wait up to wait, rounded up to the second, for a job to be pushed.
+func (c *Client) Dequeue(owner, queue string, max int, wait, visibility time.Duration, maxDeliveries int) ([]QueuedJob, error) {
+	if err := c.requeueExpired(owner, queue, maxDeliveries, nil); err != nil {
+		return nil, err
+	}
+
+	until := time.Now().Add(wait)
+	jobs := make([]QueuedJob, 0, max)
+	for {
+		for len(jobs) < max {
+			qj, err := c.pop(owner, queue, visibility)
+			if err != nil {
+				return nil, err
+			}
+			if qj == nil {
+				break
+			}
+			if qj.ID != "" {
+				jobs = append(jobs, *qj)
+			}
+		}
+
+		left := until.Sub(time.Now())
+		if len(jobs) > 0 || left <= 0 {
+			return jobs, nil
+		}
+
+		// BLPOP count in seconds, 0 would block forever
+		timeout := (left + time.Second - 1) / time.Second * time.Second
+		if err := c.BLPop(timeout, signalKey(owner, queue)).Err(); err != nil && err != redis.Nil {
+			return nil, err
+		}
+	}
+}
+
+// pop a job from a queue, marking it in flight.
+// Return nil if the queue is empty, an empty job if the popped job must not be delivered.
+func (c *Client) pop(owner, queue string, visibility time.Duration) (*QueuedJob, error) {
+	now := time.Now()
+	res, err := c.Eval(popScript, []string{queueKey(owner, queue), inflightKey(owner, queue)}, now.Add(visibility).Unix()).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.(string)
+
+	qj, err := c.queuedJob(owner, id)
+	if err != nil {
+		return nil, err
+	}
+	if qj != nil && qj.Deliveries == 0 {
+		// The first delivery compete with the expiry
+		claimed, err := c.ZRem(expiriesKey, owner+":"+id).Result()
+		if err != nil {
+			return nil, err
+		}
+		if claimed == 0 && qj.Job.Epsilon > 0 {
+			qj = nil
+		}
+	}
+	if qj == nil { // settled or expired meanwhile
+		return &QueuedJob{}, c.ZRem(inflightKey(owner, queue), id).Err()
+	}
+
+	qj.Deliveries++
+	qj.PulledAt = now.Unix()
+	if err := c.saveQueuedJob(owner, *qj); err != nil {
+		return nil, err
+	}
+	return qj, nil
+}
+
+// Settle remove an in flight job from its queue.
+// Return nil if the job is not in flight.
+func (c *Client) Settle(owner, id string) (*QueuedJob, error) {
+	qj, err := c.queuedJob(owner, id)
+	if err != nil || qj == nil {
+		return nil, err
+	}
+
+	removed, err := c.ZRem(inflightKey(owner, qj.Queue), id).Result()
+	if err != nil {
+		return nil, err
+	}
+	if removed == 0 {
+		return nil, nil
+	}
+
+	if err := c.HDel(queuedJobsKey(owner), id).Err(); err != nil {
+		return nil, err
+	}
+	return qj, nil
+}
+
+// Requeue a settled job at the end of its queue.
+func (c *Client) Requeue(owner string, qj QueuedJob) error {
+	out, err := json.Marshal(qj)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{queuedJobsKey(owner), queueKey(owner, qj.Queue), queuesKey, expiriesKey, signalKey(owner, qj.Queue)}
+	return c.Eval(enqueueScript, keys, qj.ID, string(out), owner+" "+qj.Queue, owner+":"+qj.ID, 0, signalTTL).Err()
+}
+
+// Stale pass the queued jobs not pulled within their epsilon to expire,
+// and the in flight jobs whose visibility timeout expired after maxDeliveries to exhaust,
+// then remove them. Other in flight jobs whose visibility timeout expired are redelivered.
+// A job is kept, and passed again on the next call, if its callback return an error.
+func (c *Client) Stale(now time.Time, maxDeliveries int, expire, exhaust func(QueuedJob) error) error {
+	members, err := c.ZRangeByScore(expiriesKey, redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		// The expiry compete with the first delivery
+		claimed, err := c.ZRem(expiriesKey, m).Result()
+		if err != nil {
+			return err
+		}
+		owner, id, ok := splitMember(m)
+		if claimed == 0 || !ok { // pulled concurrently
+			continue
+		}
+
+		qj, err := c.queuedJob(owner, id)
+		if err != nil {
+			return err
+		}
+		if qj == nil {
+			continue
+		}
+
+		if err := expire(*qj); err != nil {
+			if zErr := c.ZAdd(expiriesKey, redis.Z{Score: float64(now.Unix()), Member: m}).Err(); zErr != nil {
+				return zErr
+			}
+			return err
+		}
+
+		if err := c.LRem(queueKey(owner, qj.Queue), 0, qj.ID).Err(); err != nil {
+			return err
+		}
+		if err := c.ZRem(inflightKey(owner, qj.Queue), qj.ID).Err(); err != nil {
+			return err
+		}
+		if err := c.HDel(queuedJobsKey(owner), qj.ID).Err(); err != nil {
+			return err
+		}
+	}
+
+	queues, err := c.SMembers(queuesKey).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, q := range queues {
+		segs := strings.SplitN(q, " ", 2)
+		if len(segs) != 2 {
+			continue
+		}
+
+		if err := c.requeueExpired(segs[0], segs[1], maxDeliveries, exhaust); err != nil {
+			return err
+		}
+
+		keys := []string{queueKey(segs[0], segs[1]), inflightKey(segs[0], segs[1]), queuesKey}
+		if err := c.Eval(forgetScript, keys, q).Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// requeueExpired push back the in flight jobs whose visibility timeout expired.
+// Jobs delivered maxDeliveries times are kept in flight, or passed to drop then removed if drop is set.
+func (c *Client) requeueExpired(owner, queue string, maxDeliveries int, drop func(QueuedJob) error) error {
+	now := time.Now()
+	ids, err := c.ZRangeByScore(inflightKey(owner, queue), redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		qj, err := c.queuedJob(owner, id)
+		if err != nil {
+			return err
+		}
+
+		if qj != nil && maxDeliveries > 0 && qj.Deliveries >= maxDeliveries {
+			if drop == nil {
+				continue
+			}
+
+			// The drop compete with the settlement
+			claimed, err := c.ZRem(inflightKey(owner, queue), id).Result()
+			if err != nil {
+				return err
+			}
+			if claimed == 0 { // settled concurrently
+				continue
+			}
+
+			if err := drop(*qj); err != nil {
+				if zErr := c.ZAdd(inflightKey(owner, queue), redis.Z{Score: float64(now.Unix()), Member: id}).Err(); zErr != nil {
+					return zErr
+				}
+				return err
+			}
+
+			if err := c.HDel(queuedJobsKey(owner), id).Err(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		keys := []string{inflightKey(owner, queue), queueKey(owner, queue), signalKey(owner, queue)}
+		if err := c.Eval(redeliverScript, keys, id, signalTTL).Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) queuedJob(owner, id string) (*QueuedJob, error) {
+	val, err := c.HGet(queuedJobsKey(owner), id).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var qj QueuedJob
+	if err := json.Unmarshal([]byte(val), &qj); err != nil {
+		return nil, err
+	}
+	return &qj, nil
+}
+
+func (c *Client) saveQueuedJob(owner string, qj QueuedJob) error {
+	out, err := json.Marshal(qj)
+	if err != nil {
+		return err
+	}
+	return c.HSet(queuedJobsKey(owner), qj.ID, string(out)).Err()
+}
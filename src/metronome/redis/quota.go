@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"strconv"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// QuotaTaskTTL expire the accounted tasks not refreshed by their scheduler,
+// as the tasks removed while their scheduler was down.
+const QuotaTaskTTL = 10 * time.Minute
+
+// acquireTaskScript add a task to the user tasks, by refresh time, while the quota allow it.
+// ARGV: task guid, max tasks, expired before, now, ttl.
+const acquireTaskScript = `redis.call("zremrangebyscore", KEYS[1], "-inf", ARGV[3])
+if not redis.call("zscore", KEYS[1], ARGV[1]) and redis.call("zcard", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("zadd", KEYS[1], ARGV[4], ARGV[1])
+redis.call("expire", KEYS[1], ARGV[5])
+return 1`
+
+func quotaTasksKey(userID string) string {
+	return "quota:active:" + userID
+}
+
+func quotaExecutionsKey(userID string, minute int64) string {
+	return "quota:executions:" + userID + ":" + strconv.FormatInt(minute, 10)
+}
+
+// AcquireTask account a task in the user quota.
+// Return false if the user already own max tasks.
+func (c *Client) AcquireTask(userID, guid string, max int) (bool, error) {
+	now := time.Now()
+	ttl := int64(QuotaTaskTTL / time.Second)
+	res, err := c.Eval(acquireTaskScript, []string{quotaTasksKey(userID)}, guid, max, now.Add(-QuotaTaskTTL).Unix(), now.Unix(), ttl).Result()
+	if err != nil {
+		return false, err
+	}
+
+	acquired, ok := res.(int64)
+	return ok && acquired == 1, nil
+}
+
+// RefreshTasks keep the tasks of a user accounted for QuotaTaskTTL.
+func (c *Client) RefreshTasks(userID string, guids []string) error {
+	now := float64(time.Now().Unix())
+	members := make([]redis.Z, 0, len(guids))
+	for _, guid := range guids {
+		members = append(members, redis.Z{Score: now, Member: guid})
+	}
+
+	if err := c.ZAdd(quotaTasksKey(userID), members...).Err(); err != nil {
+		return err
+	}
+	return c.Expire(quotaTasksKey(userID), QuotaTaskTTL).Err()
+}
+
+// ReleaseTask remove a task from the user quota.
+func (c *Client) ReleaseTask(userID, guid string) error {
+	return c.ZRem(quotaTasksKey(userID), guid).Err()
+}
+
+// Executions account executions of a user in the minute of at.
+// Return the user executions count of the minute, the new ones included.
+func (c *Client) Executions(userID string, at time.Time, count int) (int64, error) {
+	minute := at.Unix() / 60
+	total, err := c.IncrBy(quotaExecutionsKey(userID, minute), int64(count)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// Keep the counter while the schedulers may dispatch the minute
+	if err := c.Expire(quotaExecutionsKey(userID, minute), 2*time.Minute).Err(); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
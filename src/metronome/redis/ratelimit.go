@@ -0,0 +1,41 @@
+package redis
+
+import (
+	"time"
+)
+
+// takeScript take a token from a bucket refilled at rate tokens per second.
+// Return 0 if a token was taken, else the milliseconds to wait for one.
+const takeScript = `local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call("hmget", KEYS[1], "tokens", "at")
+local tokens = tonumber(bucket[1]) or burst
+local at = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - at) * rate / 1000)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call("hmset", KEYS[1], "tokens", tostring(tokens), "at", now)
+redis.call("pexpire", KEYS[1], math.ceil(burst / rate * 1000))
+return wait`
+
+func rateLimitKey(key string) string {
+	return "ratelimit:" + key
+}
+
+// Take a token from the bucket of a key, holding up to burst tokens.
+// Return the duration to wait for a token, 0 if one was taken.
+func (c *Client) Take(key string, rate float64, burst int) (time.Duration, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	res, err := c.Eval(takeScript, []string{rateLimitKey(key)}, rate, burst, now).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	wait, _ := res.(int64)
+	return time.Duration(wait) * time.Millisecond, nil
+}
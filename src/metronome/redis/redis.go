@@ -34,8 +34,3 @@ func DB() *Client {
 	})
 	return d.DB
 }
-
-// PublishTopic send a message to a given topic
-func (c *Client) PublishTopic(channel, topic, message string) *redis.IntCmd {
-	return c.Publish(channel, topic+":"+message)
-}
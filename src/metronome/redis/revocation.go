@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"strconv"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+func revokedTokenKey(jti string) string {
+	return "revoked:token:" + jti
+}
+
+func revokedSessionKey(session string) string {
+	return "revoked:session:" + session
+}
+
+func revokedUserKey(userID string) string {
+	return "revoked:user:" + userID
+}
+
+// RevokeToken revoke an access token by id.
+// The revocation is kept for ttl, the access tokens lifetime.
+func (c *Client) RevokeToken(jti string, ttl time.Duration) error {
+	return c.Set(revokedTokenKey(jti), 1, ttl).Err()
+}
+
+// RevokeSession revoke the access tokens of a session.
+func (c *Client) RevokeSession(session string, ttl time.Duration) error {
+	return c.Set(revokedSessionKey(session), 1, ttl).Err()
+}
+
+// RevokeUser revoke the access tokens of a user issued until now.
+// The revocation time is kept in milliseconds, as the tokens issue time.
+func (c *Client) RevokeUser(userID string, ttl time.Duration) error {
+	return c.Set(revokedUserKey(userID), time.Now().UnixNano()/int64(time.Millisecond), ttl).Err()
+}
+
+// Revoked check if an access token is revoked, by id, session or user.
+// issuedAt is the token issue time in milliseconds.
+func (c *Client) Revoked(jti, session, userID string, issuedAt int64) (bool, error) {
+	vals, err := c.MGet(revokedTokenKey(jti), revokedSessionKey(session), revokedUserKey(userID)).Result()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+
+	if len(jti) > 0 && vals[0] != nil {
+		return true, nil
+	}
+	if len(session) > 0 && vals[1] != nil {
+		return true, nil
+	}
+
+	if until, ok := vals[2].(string); ok {
+		at, err := strconv.ParseInt(until, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		// tokens issued right after the revocation, on login, stay valid
+		return issuedAt < at, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,145 @@
+package redis
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"gopkg.in/redis.v5"
+
+	"github.com/ovh/metronome/src/metronome/models"
+)
+
+// RunningJob is an async job waiting for completion.
+type RunningJob struct {
+	ID        string     `json:"id"`
+	Job       models.Job `json:"job"`
+	StartedAt int64      `json:"startedAt"`
+}
+
+const deadlinesKey = "running:deadlines"
+
+func runningKey(owner string) string {
+	return "running:" + owner
+}
+
+// Run register an async job.
+// The job deadline is set from now if any.
+func (c *Client) Run(rj RunningJob) error {
+	out, err := json.Marshal(rj)
+	if err != nil {
+		return err
+	}
+
+	if err := c.HSet(runningKey(rj.Job.Owner()), rj.ID, string(out)).Err(); err != nil {
+		return err
+	}
+
+	if rj.Job.Deadline > 0 {
+		return c.setDeadline(rj.Job.Owner(), rj.ID, rj.Job.Deadline)
+	}
+	return nil
+}
+
+// Heartbeat renew the deadline of a running job.
+// Return false if the job is not running.
+func (c *Client) Heartbeat(owner, id string) (bool, error) {
+	rj, err := c.runningJob(owner, id)
+	if err != nil || rj == nil {
+		return false, err
+	}
+
+	if rj.Job.Deadline > 0 {
+		if err := c.setDeadline(owner, id, rj.Job.Deadline); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Complete unregister a running job.
+// Return nil if the job is not running.
+func (c *Client) Complete(owner, id string) (*RunningJob, error) {
+	rj, err := c.runningJob(owner, id)
+	if err != nil || rj == nil {
+		return nil, err
+	}
+
+	removed, err := c.HDel(runningKey(owner), id).Result()
+	if err != nil {
+		return nil, err
+	}
+	if removed == 0 { // completed concurrently
+		return nil, nil
+	}
+
+	if err := c.ZRem(deadlinesKey, owner+":"+id).Err(); err != nil {
+		return nil, err
+	}
+	return rj, nil
+}
+
+// Overdue pass each running job whose deadline is exceeded to fail, then unregister it.
+// A job is left running, and passed again on the next call, if fail return an error.
+func (c *Client) Overdue(now time.Time, fail func(RunningJob) error) error {
+	members, err := c.ZRangeByScore(deadlinesKey, redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		claimed, err := c.ZRem(deadlinesKey, m).Result()
+		if err != nil {
+			return err
+		}
+		owner, id, ok := splitMember(m)
+		if claimed == 0 || !ok { // swept concurrently
+			continue
+		}
+
+		rj, err := c.runningJob(owner, id)
+		if err != nil {
+			return err
+		}
+		if rj == nil { // completed meanwhile
+			continue
+		}
+
+		if err := fail(*rj); err != nil {
+			if zErr := c.ZAdd(deadlinesKey, redis.Z{Score: float64(now.Unix()), Member: m}).Err(); zErr != nil {
+				return zErr
+			}
+			return err
+		}
+
+		if _, err := c.Complete(owner, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) setDeadline(owner, id string, deadline int64) error {
+	at := float64(time.Now().Unix() + deadline)
+	return c.ZAdd(deadlinesKey, redis.Z{Score: at, Member: owner + ":" + id}).Err()
+}
+
+func (c *Client) runningJob(owner, id string) (*RunningJob, error) {
+	val, err := c.HGet(runningKey(owner), id).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rj RunningJob
+	if err := json.Unmarshal([]byte(val), &rj); err != nil {
+		return nil, err
+	}
+	return &rj, nil
+}
@@ -0,0 +1,39 @@
+package redis
+
+import (
+	"time"
+
+	"gopkg.in/redis.v5"
+
+	"github.com/ovh/metronome/src/metronome/core"
+)
+
+// redeemTicketScript get then delete a ticket, so it is used once.
+const redeemTicketScript = `local v = redis.call("get", KEYS[1])
+redis.call("del", KEYS[1])
+return v`
+
+// ticketKey index the tickets by hash, as the API keys.
+func ticketKey(ticket string) string {
+	return "ticket:" + core.Sha256(ticket)
+}
+
+// IssueTicket store a ticket value for ttl.
+func (c *Client) IssueTicket(ticket, value string, ttl time.Duration) error {
+	return c.Set(ticketKey(ticket), value, ttl).Err()
+}
+
+// RedeemTicket return a ticket value and forget it.
+// Return an empty value if the ticket is unknown, expired or already redeemed.
+func (c *Client) RedeemTicket(ticket string) (string, error) {
+	res, err := c.Eval(redeemTicketScript, []string{ticketKey(ticket)}).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	value, _ := res.(string)
+	return value, nil
+}
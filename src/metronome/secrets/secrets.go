@@ -0,0 +1,210 @@
+// Package secrets envelope encrypt the task secrets.
+//
+// Each task secrets are encrypted with a random data key, itself encrypted
+// with a master key. Master keys are rotated by adding a new key, sealing
+// with it, and keeping the old one while tasks sealed with it remain.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// KeyConfig describe a master key, Key is a 32 bytes hex secret.
+type KeyConfig struct {
+	ID  string `mapstructure:"kid"`
+	Key string `mapstructure:"key"`
+}
+
+// Keyring hold the master keys.
+// Secrets are sealed with the master key and opened with any key.
+type Keyring struct {
+	master string
+	keys   map[string]cipher.AEAD
+}
+
+// ErrDisabled is returned when no master key is configured.
+var ErrDisabled = errors.New("Secrets are not enabled")
+
+var (
+	keyringOnce sync.Once
+	keyring     *Keyring
+	keyringErr  error
+)
+
+// Keys return the keyring configured by secrets.keys and secrets.master.
+// Return ErrDisabled if no key is configured.
+func Keys() (*Keyring, error) {
+	keyringOnce.Do(func() {
+		var configs []KeyConfig
+		if err := viper.UnmarshalKey("secrets.keys", &configs); err != nil {
+			keyringErr = err
+			return
+		}
+
+		if len(configs) == 0 {
+			keyringErr = ErrDisabled
+			return
+		}
+
+		keyring, keyringErr = NewKeyring(configs, viper.GetString("secrets.master"))
+	})
+	return keyring, keyringErr
+}
+
+// NewKeyring return a keyring sealing with the key of id master.
+// The master key default to the only key.
+func NewKeyring(configs []KeyConfig, master string) (*Keyring, error) {
+	kr := &Keyring{
+		master: master,
+		keys:   make(map[string]cipher.AEAD),
+	}
+
+	for _, c := range configs {
+		if len(c.ID) == 0 || strings.ContainsAny(c.ID, ". \t\n") {
+			return nil, fmt.Errorf("Bad secrets key id '%s'", c.ID)
+		}
+
+		secret, err := hex.DecodeString(c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Bad secrets key '%s': %v", c.ID, err)
+		}
+		if len(secret) != 32 {
+			return nil, fmt.Errorf("Bad secrets key '%s': must be 32 bytes", c.ID)
+		}
+
+		aead, err := newAEAD(secret)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[c.ID] = aead
+	}
+
+	if len(kr.master) == 0 && len(configs) == 1 {
+		kr.master = configs[0].ID
+	}
+
+	if _, ok := kr.keys[kr.master]; !ok {
+		return nil, fmt.Errorf("Unknown secrets master key '%s'", kr.master)
+	}
+
+	return kr, nil
+}
+
+// Seal encrypt secrets.
+// The sealed form is the master key id, the encrypted data key and the encrypted secrets.
+func (kr *Keyring) Seal(secrets map[string]string) (string, error) {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return "", err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := encrypt(kr.keys[kr.master], dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := encrypt(data, plain)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{
+		kr.master,
+		base64.RawURLEncoding.EncodeToString(wrapped),
+		base64.RawURLEncoding.EncodeToString(sealed),
+	}, "."), nil
+}
+
+// Open decrypt sealed secrets.
+func (kr *Keyring) Open(sealed string) (map[string]string, error) {
+	parts := strings.Split(sealed, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Bad sealed secrets")
+	}
+
+	master, ok := kr.keys[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("Unknown secrets key '%s'", parts[0])
+	}
+
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("Bad sealed secrets data key")
+	}
+
+	dataKey, err := decrypt(master, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("Bad sealed secrets")
+	}
+
+	plain, err := decrypt(data, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// newAEAD return an AES-GCM cipher.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt prefix the ciphertext with its random nonce.
+func encrypt(aead cipher.AEAD, plain []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// decrypt a nonce prefixed ciphertext.
+func decrypt(aead cipher.AEAD, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("Bad ciphertext")
+	}
+
+	nonce := ciphertext[:aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[aead.NonceSize():], nil)
+}
@@ -0,0 +1,98 @@
+package secrets_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/ovh/metronome/src/metronome/secrets"
+)
+
+var (
+	oldKey = secrets.KeyConfig{ID: "2018-01", Key: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"}
+	newKey = secrets.KeyConfig{ID: "2018-02", Key: "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"}
+)
+
+var _ = Describe("Keyring", func() {
+	It("should open sealed secrets", func() {
+		kr, err := secrets.NewKeyring([]secrets.KeyConfig{oldKey}, "")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		sealed, err := kr.Seal(map[string]string{"apiKey": "s3cr3t"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(sealed).ShouldNot(ContainSubstring("s3cr3t"))
+		Ω(sealed).Should(HavePrefix("2018-01."))
+		Ω(strings.ContainsAny(sealed, " ")).Should(BeFalse())
+
+		opened, err := kr.Open(sealed)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(opened).Should(Equal(map[string]string{"apiKey": "s3cr3t"}))
+	})
+
+	It("should use a new data key for each seal", func() {
+		kr, err := secrets.NewKeyring([]secrets.KeyConfig{oldKey}, "")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		a, err := kr.Seal(map[string]string{"apiKey": "s3cr3t"})
+		Ω(err).ShouldNot(HaveOccurred())
+		b, err := kr.Seal(map[string]string{"apiKey": "s3cr3t"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(a).ShouldNot(Equal(b))
+	})
+
+	It("should open secrets sealed with a rotated key", func() {
+		before, err := secrets.NewKeyring([]secrets.KeyConfig{oldKey}, "")
+		Ω(err).ShouldNot(HaveOccurred())
+		sealed, err := before.Seal(map[string]string{"apiKey": "s3cr3t"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		after, err := secrets.NewKeyring([]secrets.KeyConfig{oldKey, newKey}, "2018-02")
+		Ω(err).ShouldNot(HaveOccurred())
+		opened, err := after.Open(sealed)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(opened["apiKey"]).Should(Equal("s3cr3t"))
+
+		resealed, err := after.Seal(opened)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(resealed).Should(HavePrefix("2018-02."))
+	})
+
+	It("should reject tampered secrets", func() {
+		kr, err := secrets.NewKeyring([]secrets.KeyConfig{oldKey}, "")
+		Ω(err).ShouldNot(HaveOccurred())
+		sealed, err := kr.Seal(map[string]string{"apiKey": "s3cr3t"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		tampered := sealed[:len(sealed)-2] + "AA"
+		if tampered == sealed {
+			tampered = sealed[:len(sealed)-2] + "BB"
+		}
+		_, err = kr.Open(tampered)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should reject unknown keys", func() {
+		kr, err := secrets.NewKeyring([]secrets.KeyConfig{newKey}, "")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		other, err := secrets.NewKeyring([]secrets.KeyConfig{oldKey}, "")
+		Ω(err).ShouldNot(HaveOccurred())
+		sealed, err := other.Seal(map[string]string{"apiKey": "s3cr3t"})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = kr.Open(sealed)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should reject bad keys", func() {
+		_, err := secrets.NewKeyring([]secrets.KeyConfig{{ID: "short", Key: "0001"}}, "")
+		Ω(err).Should(HaveOccurred())
+
+		_, err = secrets.NewKeyring([]secrets.KeyConfig{oldKey, newKey}, "")
+		Ω(err).Should(HaveOccurred())
+
+		_, err = secrets.NewKeyring([]secrets.KeyConfig{{ID: "a.b", Key: oldKey.Key}}, "")
+		Ω(err).Should(HaveOccurred())
+	})
+})
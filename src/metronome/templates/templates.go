@@ -0,0 +1,189 @@
+// Package templates render task payload and URN templates.
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/ovh/metronome/src/metronome/models"
+)
+
+// Context is the data available to templates for a job execution.
+type Context struct {
+	// TaskID is the ID of the executed task
+	TaskID string
+	// ScheduledAt is the planned execution time
+	ScheduledAt time.Time
+	// Now is the effective execution time
+	Now time.Time
+	// Attempt is the execution attempt, starting at 1
+	Attempt int
+	// Upstream is the response of the last upstream task done, nil unless it capture responses
+	Upstream *models.Response
+	// Upstreams are the responses of the upstream tasks by task ID
+	Upstreams map[string]*models.Response
+	// Secrets are the task secrets, opened by the worker
+	Secrets map[string]string
+}
+
+// fields are the context fields usable by templates.
+var fields = map[string]bool{
+	"TaskID":      true,
+	"ScheduledAt": true,
+	"Now":         true,
+	"Attempt":     true,
+	"Upstream":    true,
+	"Upstreams":   true,
+	"Secrets":     true,
+}
+
+var funcs = template.FuncMap{
+	"rfc3339": func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339)
+	},
+	"unix": func(t time.Time) int64 {
+		return t.Unix()
+	},
+}
+
+// Parse a template string.
+func Parse(text string) (*template.Template, error) {
+	return template.New("").Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+// Check a template string, validating its syntax and the context fields it use.
+func Check(text string) error {
+	t, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	return checkNode(t.Tree.Root)
+}
+
+// RenderString execute a template string.
+func RenderString(text string, ctx Context) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	t, err := Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, ctx); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Render a payload, executing the templates found in string values.
+func Render(payload map[string]interface{}, ctx Context) (map[string]interface{}, error) {
+	out, err := walk(payload, func(s string) (interface{}, error) {
+		return RenderString(s, ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.(map[string]interface{}), nil
+}
+
+// Validate check the templates found in a payload.
+func Validate(payload map[string]interface{}) error {
+	_, err := walk(payload, func(s string) (interface{}, error) {
+		return s, Check(s)
+	})
+	return err
+}
+
+// walk apply fn to the template strings of a JSON value.
+func walk(v interface{}, fn func(string) (interface{}, error)) (interface{}, error) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, e := range value {
+			r, err := walk(e, fn)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, e := range value {
+			r, err := walk(e, fn)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	case string:
+		if !strings.Contains(value, "{{") {
+			return value, nil
+		}
+		return fn(value)
+	default:
+		return v, nil
+	}
+}
+
+// checkNode check the context fields used by a template node.
+func checkNode(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Nodes {
+			if err := checkNode(c); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkNode(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Cmds {
+			if err := checkNode(c); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			if err := checkNode(a); err != nil {
+				return err
+			}
+		}
+	case *parse.FieldNode:
+		if !fields[n.Ident[0]] {
+			return fmt.Errorf("Unknown template field %s", n.Ident[0])
+		}
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		// range and with change the dot, only the pipeline refer to the context
+		return checkNode(n.Pipe)
+	case *parse.WithNode:
+		return checkNode(n.Pipe)
+	}
+	return nil
+}
+
+func checkBranch(n *parse.BranchNode) error {
+	if err := checkNode(n.Pipe); err != nil {
+		return err
+	}
+	if err := checkNode(n.List); err != nil {
+		return err
+	}
+	return checkNode(n.ElseList)
+}
@@ -24,6 +24,7 @@ func init() {
 	RootCmd.Flags().StringSlice("kafka.brokers", []string{"localhost:9092"}, "kafka brokers address")
 	RootCmd.Flags().String("redis.addr", "127.0.0.1:6379", "redis address")
 	RootCmd.Flags().String("metrics.addr", "127.0.0.1:9100", "metrics address")
+	RootCmd.Flags().Int("scheduler.horizon", 20, "planning horizon in seconds")
 
 	if err := viper.BindPFlags(RootCmd.Flags()); err != nil {
 		log.WithError(err).Error("Could not bind the flags")
@@ -48,6 +49,8 @@ func initConfig() {
 	viper.SetDefault("kafka.topics.tasks", "tasks")
 	viper.SetDefault("kafka.topics.jobs", "jobs")
 	viper.SetDefault("kafka.topics.states", "states")
+	viper.SetDefault("kafka.topics.calendars", "calendars")
+	viper.SetDefault("kafka.topics.audit", "audit")
 	viper.SetDefault("kafka.groups.schedulers", "schedulers")
 	viper.SetDefault("kafka.groups.aggregators", "aggregators")
 	viper.SetDefault("kafka.groups.workers", "workers")
@@ -105,6 +108,12 @@ Complete documentation is available at http://ovh.github.io/metronome`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Info("Metronome Scheduler starting")
 
+		log.Info("Loading calendars")
+		cc, err := routines.NewCalendarConsumer()
+		if err != nil {
+			log.WithError(err).Fatal("Could not start the calendar consumer")
+		}
+
 		log.Info("Loading tasks")
 		tc, err := routines.NewTaskConsumer()
 		if err != nil {
@@ -128,13 +137,14 @@ Complete documentation is available at http://ovh.github.io/metronome`,
 				schedulers.Add(1)
 				go func() {
 					log.Infof("Scheduler start %v", partition.Partition)
-					ts, err := routines.NewTaskScheduler(partition.Partition, partition.Tasks)
+					ts, err := routines.NewTaskScheduler(partition.Partition, partition.Tasks, cc.Calendars())
 					if err != nil {
 						log.WithError(err).Error("Could not create a new task scheduler")
 						return
 					}
 
 					tc.WaitForDrain()
+					cc.WaitForDrain()
 					log.Infof("Scheduler tasks loaded %v", partition.Partition)
 					if running {
 						if err = ts.Start(); err != nil {
@@ -161,5 +171,9 @@ Complete documentation is available at http://ovh.github.io/metronome`,
 
 		log.Infof("Consumer halted")
 		schedulers.Wait()
+
+		if err = cc.Close(); err != nil {
+			log.WithError(err).Error("Could not stop gracefully the calendar consumer")
+		}
 	},
 }
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ovh/metronome/src/metronome/models"
+)
+
+// Calendars is a calendar registry shared between schedulers.
+type Calendars struct {
+	calendars map[string]*models.Calendar
+	mutex     sync.RWMutex
+}
+
+// NewCalendars return a new empty calendar registry.
+func NewCalendars() *Calendars {
+	return &Calendars{
+		calendars: make(map[string]*models.Calendar),
+	}
+}
+
+// Set add or replace a calendar.
+func (c *Calendars) Set(calendar models.Calendar) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calendars[calendar.GUID] = &calendar
+}
+
+// Delete remove a calendar.
+func (c *Calendars) Delete(guid string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.calendars, guid)
+}
+
+// Excludes check if a time is excluded by a calendar.
+// Unknown calendars exclude nothing.
+func (c *Calendars) Excludes(guid string, t time.Time) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	calendar, ok := c.calendars[guid]
+	if !ok {
+		return false
+	}
+	return calendar.Excludes(t)
+}
@@ -3,7 +3,9 @@ package core
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"math"
+	"math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -25,6 +27,22 @@ type Entry struct {
 	months int64
 	years  int64
 
+	// jitter window in seconds and current offset
+	jitter int64
+	spread string
+	offset int64
+
+	// calendar GUID and registry
+	calendar  string
+	calendars *Calendars
+
+	// async completion deadline in seconds, 0 if none
+	deadline int64
+
+	// executions range as unix timestamps, 0 if unbounded
+	notBefore int64
+	notAfter  int64
+
 	next    int64
 	planned int64
 
@@ -70,13 +88,83 @@ func NewEntry(task models.Task) (*Entry, error) {
 		return nil, fmt.Errorf("Null period %v", task.Schedule)
 	}
 
+	if task.NotBefore != nil {
+		e.notBefore = task.NotBefore.Unix()
+	}
+	if task.NotAfter != nil {
+		e.notAfter = task.NotAfter.Unix()
+	}
+	if e.notBefore > 0 && e.notAfter > 0 && e.notAfter < e.notBefore {
+		return nil, fmt.Errorf("Not after %v before not before %v", task.NotAfter, task.NotBefore)
+	}
+
+	e.deadline = task.DeadlineSeconds()
+
+	if len(task.Calendar) > 0 {
+		e.calendar = models.CalendarGUID(task.UserID, task.Calendar)
+	}
+
+	if len(task.Jitter) > 0 {
+		e.jitter = int64(ParseDuration(task.Jitter).Seconds())
+		e.spread = task.Spread
+		if len(e.spread) == 0 {
+			e.spread = models.SpreadHash
+		}
+		if e.spread != models.SpreadHash && e.spread != models.SpreadRandom {
+			return nil, fmt.Errorf("Bad spread %v", task.Spread)
+		}
+		if e.jitter >= int64(e.period) {
+			return nil, fmt.Errorf("Jitter %v exceed period %v", task.Jitter, task.Schedule)
+		}
+		e.offset = e.nextOffset()
+	}
+
 	return e, nil
 }
 
+// nextOffset return the jitter offset to apply to the next execution.
+// Hash offsets are stable for a given GUID.
+func (e *Entry) nextOffset() int64 {
+	if e.jitter <= 0 {
+		return 0
+	}
+
+	if e.spread == models.SpreadRandom {
+		return rand.Int63n(e.jitter)
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(e.task.GUID)) // nolint: errcheck
+	return int64(h.Sum64() % uint64(e.jitter))
+}
+
 // SameAs check if entry is semanticaly the same as a task.
 func (e *Entry) SameAs(t models.Task) bool {
 	return e.task.URN == t.URN &&
-		e.task.Schedule == t.Schedule
+		e.task.Schedule == t.Schedule &&
+		e.task.Jitter == t.Jitter &&
+		e.task.Spread == t.Spread &&
+		e.task.Calendar == t.Calendar &&
+		e.task.Completion == t.Completion &&
+		e.task.Deadline == t.Deadline &&
+		e.task.Concurrency == t.Concurrency &&
+		e.task.CaptureResponse == t.CaptureResponse &&
+		e.task.Template == t.Template &&
+		unixOrZero(e.task.NotBefore) == unixOrZero(t.NotBefore) &&
+		unixOrZero(e.task.NotAfter) == unixOrZero(t.NotAfter)
+}
+
+// unixOrZero return the unix timestamp of a time, 0 if unset.
+func unixOrZero(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// UseCalendars set the calendar registry used to check exclusions.
+func (e *Entry) UseCalendars(calendars *Calendars) {
+	e.calendars = calendars
 }
 
 // UserID return the task user ID.
@@ -84,6 +172,11 @@ func (e *Entry) UserID() string {
 	return e.task.UserID
 }
 
+// ProjectID return the task project ID, empty if none.
+func (e *Entry) ProjectID() string {
+	return e.task.ProjectID
+}
+
 // Epsilon return the task epsilon.
 func (e *Entry) Epsilon() int64 {
 	return int64(e.epsilon)
@@ -94,6 +187,36 @@ func (e *Entry) URN() string {
 	return e.task.URN
 }
 
+// Async check if the task jobs are completed asynchronously.
+func (e *Entry) Async() bool {
+	return e.task.Completion == models.CompletionAsync
+}
+
+// Deadline return the async completion deadline in seconds, 0 if none.
+func (e *Entry) Deadline() int64 {
+	return e.deadline
+}
+
+// Concurrency return the task concurrency policy.
+func (e *Entry) Concurrency() string {
+	return e.task.Concurrency
+}
+
+// Capture check if the task jobs keep the target response.
+func (e *Entry) Capture() bool {
+	return e.task.CaptureResponse
+}
+
+// Template check if the task jobs render their templates.
+func (e *Entry) Template() bool {
+	return e.task.Template
+}
+
+// ID return the task ID.
+func (e *Entry) ID() string {
+	return e.task.ID
+}
+
 // GUID return the task GUID.
 func (e *Entry) GUID() string {
 	return e.task.GUID
@@ -109,10 +232,50 @@ func (e *Entry) SetPayload(payload map[string]interface{}) {
 	e.task.Payload = payload
 }
 
-// Next return the next execution time.
-// Return -1 if invalid.
+// Secrets return the Task sealed secrets
+func (e *Entry) Secrets() string {
+	return e.task.SealedSecrets
+}
+
+// SetSecrets update Task sealed secrets
+func (e *Entry) SetSecrets(secrets string) {
+	e.task.SealedSecrets = secrets
+}
+
+// Next return the next execution time, jitter included.
+// Return -1 if invalid or exhausted.
 func (e *Entry) Next() int64 {
-	return e.next
+	if e.next < 0 {
+		return -1
+	}
+	return e.next + e.offset
+}
+
+// Done check if the entry has no more execution to plan.
+func (e *Entry) Done() bool {
+	return e.initialized && e.next < 0
+}
+
+// Excluded check if the next execution is excluded by the task calendar.
+// Excluded executions are still planned, but must not be performed.
+func (e *Entry) Excluded() bool {
+	if len(e.calendar) == 0 || e.calendars == nil || e.next < 0 {
+		return false
+	}
+	return e.calendars.Excludes(e.calendar, time.Unix(e.Next(), 0))
+}
+
+// Last check if the next execution is the last one.
+func (e *Entry) Last() bool {
+	if e.next < 0 {
+		return false
+	}
+
+	c := *e
+	if _, err := c.Plan(time.Unix(c.Next()+1, 0)); err != nil {
+		return false
+	}
+	return c.next < 0
 }
 
 // Init the planning system
@@ -120,11 +283,25 @@ func (e *Entry) Next() int64 {
 func (e *Entry) Init(now time.Time) {
 	e.initialized = true
 
+	// Random offsets are not stable across inits, only hash offsets
+	// can be used to look back for a pending execution.
+	if e.spread == models.SpreadHash {
+		now = now.Add(-time.Duration(e.offset) * time.Second)
+	}
+
+	if e.notBefore > 0 && now.Unix() < e.notBefore {
+		now = time.Unix(e.notBefore, 0)
+	}
+
 	if e.timeMode {
 		e.next = e.initTimeMode(now)
-		return // e.next, true
+	} else {
+		e.next = e.initDateMode(now)
+	}
+
+	if e.notAfter > 0 && e.next > e.notAfter {
+		e.next = -1
 	}
-	e.next = e.initDateMode(now)
 }
 
 // initTimeMode compute first iteration for time period
@@ -195,7 +372,7 @@ func (e *Entry) Plan(now time.Time) (bool, error) {
 		return false, errors.New("Unitialized entry. Please call init before")
 	}
 
-	if e.next >= now.Unix() {
+	if e.Next() >= now.Unix() {
 		return false, nil
 	}
 
@@ -226,6 +403,12 @@ func (e *Entry) Plan(now time.Time) (bool, error) {
 
 		e.next = next.Unix()
 	}
+	if e.notAfter > 0 && e.next > e.notAfter {
+		e.next = -1
+	}
+	if e.spread == models.SpreadRandom {
+		e.offset = e.nextOffset()
+	}
 
 	return true, nil
 }
@@ -223,4 +223,245 @@ var _ = Describe("Entry", func() {
 				{"2017-01-01T02:14:01Z", ""}}),
 		)
 	})
+
+	Describe("Jitter", func() {
+		jittered := func(guid, jitter, spread string) *core.Entry {
+			e, err := core.NewEntry(models.Task{
+				GUID:     guid,
+				Schedule: "R/2017-01-01T00:00:00Z/PT1H/ET1S",
+				Jitter:   jitter,
+				Spread:   spread,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			return e
+		}
+
+		start, _ := time.Parse(time.RFC3339, "2017-01-01T00:00:00Z")
+
+		It("Should reject a jitter exceeding the period", func() {
+			_, err := core.NewEntry(models.Task{
+				Schedule: "R/2017-01-01T00:00:00Z/PT1M/ET1S",
+				Jitter:   "PT2M",
+			})
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("Should reject a bad spread", func() {
+			_, err := core.NewEntry(models.Task{
+				Schedule: "R/2017-01-01T00:00:00Z/PT1H/ET1S",
+				Jitter:   "PT5M",
+				Spread:   "bad",
+			})
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("Should apply a stable hash offset within the window", func() {
+			a := jittered("GUID", "PT5M", "")
+			b := jittered("GUID", "PT5M", models.SpreadHash)
+			a.Init(start)
+			b.Init(start)
+
+			Ω(a.Next()).Should(Equal(b.Next()))
+			Ω(a.Next()).Should(BeNumerically(">=", start.Unix()))
+			Ω(a.Next()).Should(BeNumerically("<", start.Add(5*time.Minute).Unix()))
+
+			offset := a.Next() - start.Unix()
+			_, err := a.Plan(start.Add(10 * time.Minute))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(a.Next()).Should(Equal(start.Add(time.Hour).Unix() + offset))
+		})
+
+		It("Should spread tasks scheduled at the same instant", func() {
+			nexts := make(map[int64]bool)
+			for i := 0; i < 10; i++ {
+				e := jittered(fmt.Sprintf("GUID-%d", i), "PT1M", models.SpreadHash)
+				e.Init(start)
+				nexts[e.Next()] = true
+			}
+			Ω(len(nexts)).Should(BeNumerically(">", 1))
+		})
+
+		It("Should keep a pending hash offset execution on init", func() {
+			e := jittered("GUID", "PT5M", models.SpreadHash)
+			e.Init(start)
+			next := e.Next()
+
+			e.Init(time.Unix(next, 0))
+			Ω(e.Next()).Should(Equal(next))
+		})
+
+		It("Should apply a random offset within the window", func() {
+			e := jittered("GUID", "PT5M", models.SpreadRandom)
+			e.Init(start)
+
+			for i := 1; i < 10; i++ {
+				_, err := e.Plan(time.Unix(e.Next()+1, 0))
+				Ω(err).ShouldNot(HaveOccurred())
+
+				base := start.Add(time.Duration(i) * time.Hour).Unix()
+				Ω(e.Next()).Should(BeNumerically(">=", base))
+				Ω(e.Next()).Should(BeNumerically("<", base+300))
+			}
+		})
+	})
+
+	Describe("Calendar", func() {
+		calendared := func(calendars *core.Calendars) *core.Entry {
+			e, err := core.NewEntry(models.Task{
+				GUID:     "GUID",
+				UserID:   "user",
+				Schedule: "R/2017-01-01T00:00:00Z/PT1H/ET1S",
+				Calendar: "holidays",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			e.UseCalendars(calendars)
+			return e
+		}
+
+		// 2017-01-01 is a sunday
+		start, _ := time.Parse(time.RFC3339, "2017-01-01T00:00:00Z")
+
+		It("Should not exclude without known calendar", func() {
+			e := calendared(core.NewCalendars())
+			e.Init(start)
+			Ω(e.Excluded()).Should(BeFalse())
+		})
+
+		It("Should exclude dates", func() {
+			calendars := core.NewCalendars()
+			calendars.Set(models.Calendar{
+				GUID:  models.CalendarGUID("user", "holidays"),
+				Dates: []string{"2017-01-01"},
+			})
+
+			e := calendared(calendars)
+			e.Init(start)
+			Ω(e.Excluded()).Should(BeTrue())
+
+			for e.Next() < start.Add(24*time.Hour).Unix() {
+				_, err := e.Plan(time.Unix(e.Next()+1, 0))
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+			Ω(e.Excluded()).Should(BeFalse())
+		})
+
+		It("Should resolve dates in the calendar timezone", func() {
+			calendars := core.NewCalendars()
+			calendars.Set(models.Calendar{
+				GUID:     models.CalendarGUID("user", "holidays"),
+				Timezone: "America/New_York",
+				Dates:    []string{"2016-12-31"},
+			})
+
+			e := calendared(calendars)
+			e.Init(start)
+			Ω(e.Excluded()).Should(BeTrue())
+		})
+
+		It("Should exclude windows wrapping over midnight", func() {
+			calendars := core.NewCalendars()
+			calendars.Set(models.Calendar{
+				GUID: models.CalendarGUID("user", "holidays"),
+				Windows: []models.Window{{
+					Weekdays: []string{"Saturday"},
+					Start:    "22:00",
+					End:      "02:00",
+				}},
+			})
+
+			e := calendared(calendars)
+			e.Init(start)
+			Ω(e.Excluded()).Should(BeTrue())
+
+			for e.Next() < start.Add(2*time.Hour).Unix() {
+				_, err := e.Plan(time.Unix(e.Next()+1, 0))
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(e.Excluded()).Should(Equal(e.Next() < start.Add(2*time.Hour).Unix()))
+			}
+			Ω(e.Excluded()).Should(BeFalse())
+		})
+
+		It("Should not exclude once the calendar is deleted", func() {
+			calendars := core.NewCalendars()
+			calendars.Set(models.Calendar{
+				GUID:  models.CalendarGUID("user", "holidays"),
+				Dates: []string{"2017-01-01"},
+			})
+
+			e := calendared(calendars)
+			e.Init(start)
+			calendars.Delete(models.CalendarGUID("user", "holidays"))
+			Ω(e.Excluded()).Should(BeFalse())
+		})
+	})
+
+	Describe("Range", func() {
+		start, _ := time.Parse(time.RFC3339, "2017-01-01T00:00:00Z")
+
+		ranged := func(schedule string, notBefore, notAfter *time.Time) *core.Entry {
+			e, err := core.NewEntry(models.Task{
+				GUID:      "GUID",
+				Schedule:  schedule,
+				NotBefore: notBefore,
+				NotAfter:  notAfter,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			return e
+		}
+
+		at := func(d time.Duration) *time.Time {
+			t := start.Add(d)
+			return &t
+		}
+
+		It("Should reject an inverted range", func() {
+			_, err := core.NewEntry(models.Task{
+				Schedule:  "R/2017-01-01T00:00:00Z/PT1H/ET1S",
+				NotBefore: at(2 * time.Hour),
+				NotAfter:  at(time.Hour),
+			})
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("Should not run before not before", func() {
+			e := ranged("R/2017-01-01T00:00:00Z/PT1H/ET1S", at(90*time.Minute), nil)
+			e.Init(start)
+			Ω(e.Next()).Should(Equal(start.Add(2 * time.Hour).Unix()))
+		})
+
+		It("Should stop after not after", func() {
+			e := ranged("R/2017-01-01T00:00:00Z/PT1H/ET1S", nil, at(2*time.Hour))
+			e.Init(start)
+
+			runs := 0
+			for !e.Done() {
+				runs++
+				Ω(e.Last()).Should(Equal(runs == 3))
+				_, err := e.Plan(time.Unix(e.Next()+1, 0))
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+			Ω(runs).Should(Equal(3))
+			Ω(e.Next()).Should(Equal(int64(-1)))
+		})
+
+		It("Should be done when initialized after not after", func() {
+			e := ranged("R/2017-01-01T00:00:00Z/PT1H/ET1S", nil, at(2*time.Hour))
+			e.Init(start.Add(3 * time.Hour))
+			Ω(e.Done()).Should(BeTrue())
+		})
+
+		It("Should flag the last repeat", func() {
+			e := ranged("R2/2017-01-01T00:00:00Z/PT1H/ET1S", nil, nil)
+			e.Init(start)
+
+			runs := 0
+			for !e.Done() {
+				runs++
+				Ω(e.Last()).Should(Equal(runs == 3))
+				_, err := e.Plan(time.Unix(e.Next()+1, 0))
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+			Ω(runs).Should(Equal(3))
+		})
+	})
 })
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"container/heap"
+)
+
+// Queue is a min-heap of entries ordered by next execution time.
+// Entries are indexed by GUID to allow removal and update.
+type Queue struct {
+	items   []*Entry
+	indexes map[string]int
+}
+
+// NewQueue return a new empty queue.
+func NewQueue() *Queue {
+	return &Queue{
+		indexes: make(map[string]int),
+	}
+}
+
+// Len return the number of queued entries.
+func (q *Queue) Len() int {
+	return len(q.items)
+}
+
+// Less is part of heap.Interface.
+func (q *Queue) Less(i, j int) bool {
+	return q.items[i].Next() < q.items[j].Next()
+}
+
+// Swap is part of heap.Interface.
+func (q *Queue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.indexes[q.items[i].GUID()] = i
+	q.indexes[q.items[j].GUID()] = j
+}
+
+// Push is part of heap.Interface. Use Add instead.
+func (q *Queue) Push(x interface{}) {
+	e := x.(*Entry)
+	q.indexes[e.GUID()] = len(q.items)
+	q.items = append(q.items, e)
+}
+
+// Pop is part of heap.Interface. Use PopDue instead.
+func (q *Queue) Pop() interface{} {
+	n := len(q.items)
+	e := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	delete(q.indexes, e.GUID())
+	return e
+}
+
+// Add an entry to the queue, replacing any entry with the same GUID.
+// Entries without next execution are not queued.
+func (q *Queue) Add(e *Entry) {
+	q.Remove(e.GUID())
+	if e.Next() < 0 {
+		return
+	}
+	heap.Push(q, e)
+}
+
+// Remove an entry from the queue.
+// Return true if the entry was queued.
+func (q *Queue) Remove(guid string) bool {
+	i, ok := q.indexes[guid]
+	if !ok {
+		return false
+	}
+	heap.Remove(q, i)
+	return true
+}
+
+// Peek return the entry with the nearest execution time.
+// Return nil if the queue is empty.
+func (q *Queue) Peek() *Entry {
+	if len(q.items) == 0 {
+		return nil
+	}
+	return q.items[0]
+}
+
+// PopDue remove and return the entry with the nearest execution time
+// if it is due at the given unix timestamp.
+// Return nil if no entry is due.
+func (q *Queue) PopDue(at int64) *Entry {
+	e := q.Peek()
+	if e == nil || e.Next() > at {
+		return nil
+	}
+	return heap.Pop(q).(*Entry)
+}
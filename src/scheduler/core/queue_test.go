@@ -0,0 +1,81 @@
+package core_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/ovh/metronome/src/metronome/models"
+
+	core "github.com/ovh/metronome/src/scheduler/core"
+)
+
+func queued(guid, schedule, now string) *core.Entry {
+	e, err := core.NewEntry(models.Task{
+		GUID:     guid,
+		Schedule: schedule,
+	})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	n, err := time.Parse(time.RFC3339, now)
+	Ω(err).ShouldNot(HaveOccurred())
+	e.Init(n)
+
+	return e
+}
+
+var _ = Describe("Queue", func() {
+	var (
+		q   *core.Queue
+		at  int64
+		now = "2017-01-01T00:00:00Z"
+	)
+
+	BeforeEach(func() {
+		q = core.NewQueue()
+		t, _ := time.Parse(time.RFC3339, now)
+		at = t.Unix()
+
+		q.Add(queued("c", "R/2017-01-01T00:00:30Z/PT1M/ET1S", now))
+		q.Add(queued("a", "R/2017-01-01T00:00:10Z/PT1M/ET1S", now))
+		q.Add(queued("b", "R/2017-01-01T00:00:20Z/PT1M/ET1S", now))
+	})
+
+	It("Should order entries by next execution", func() {
+		Ω(q.Len()).Should(Equal(3))
+		Ω(q.Peek().GUID()).Should(Equal("a"))
+	})
+
+	It("Should only pop due entries", func() {
+		Ω(q.PopDue(at)).Should(BeNil())
+
+		e := q.PopDue(at + 20)
+		Ω(e).ShouldNot(BeNil())
+		Ω(e.GUID()).Should(Equal("a"))
+
+		e = q.PopDue(at + 20)
+		Ω(e).ShouldNot(BeNil())
+		Ω(e.GUID()).Should(Equal("b"))
+
+		Ω(q.PopDue(at + 20)).Should(BeNil())
+		Ω(q.Len()).Should(Equal(1))
+	})
+
+	It("Should remove entries", func() {
+		Ω(q.Remove("a")).Should(BeTrue())
+		Ω(q.Remove("a")).Should(BeFalse())
+		Ω(q.Peek().GUID()).Should(Equal("b"))
+	})
+
+	It("Should replace entries with the same GUID", func() {
+		q.Add(queued("a", "R/2017-01-01T00:00:40Z/PT1M/ET1S", now))
+		Ω(q.Len()).Should(Equal(3))
+		Ω(q.Peek().GUID()).Should(Equal("b"))
+	})
+
+	It("Should not queue entries without next execution", func() {
+		q.Add(queued("d", "R0/2016-01-01T00:00:00Z/PT1M/ET1S", now))
+		Ω(q.Len()).Should(Equal(3))
+	})
+})
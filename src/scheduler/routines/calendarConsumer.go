@@ -0,0 +1,136 @@
+package routines
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/ovh/metronome/src/metronome/kafka"
+	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/scheduler/core"
+)
+
+// CalendarConsumer maintain the calendar registry from all the calendars topic partitions.
+type CalendarConsumer struct {
+	client     sarama.Client
+	consumer   sarama.Consumer
+	partitions []sarama.PartitionConsumer
+	calendars  *core.Calendars
+	drainWg    sync.WaitGroup
+}
+
+// NewCalendarConsumer return a new calendar consumer
+func NewCalendarConsumer() (*CalendarConsumer, error) {
+	brokers := viper.GetStringSlice("kafka.brokers")
+
+	config := kafka.NewConfig()
+	config.ClientID = "metronome-scheduler"
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := client.Partitions(kafka.TopicCalendars())
+	if err != nil {
+		return nil, err
+	}
+
+	cc := &CalendarConsumer{
+		client:    client,
+		consumer:  consumer,
+		calendars: core.NewCalendars(),
+	}
+
+	for _, part := range parts {
+		oldest, err := client.GetOffset(kafka.TopicCalendars(), part, sarama.OffsetOldest)
+		if err != nil {
+			return nil, err
+		}
+		hwm, err := client.GetOffset(kafka.TopicCalendars(), part, sarama.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+
+		pc, err := consumer.ConsumePartition(kafka.TopicCalendars(), part, sarama.OffsetOldest)
+		if err != nil {
+			return nil, err
+		}
+		cc.partitions = append(cc.partitions, pc)
+
+		drained := oldest >= hwm
+		if !drained {
+			cc.drainWg.Add(1)
+		}
+
+		go func(pc sarama.PartitionConsumer, hwm int64, drained bool) {
+			for msg := range pc.Messages() {
+				if err := cc.handleMsg(msg); err != nil {
+					log.WithError(err).Warn("Could not handle the calendar")
+				}
+
+				if !drained && (msg.Offset+1) >= hwm {
+					drained = true
+					cc.drainWg.Done()
+				}
+			}
+
+			if !drained {
+				cc.drainWg.Done()
+			}
+		}(pc, hwm, drained)
+	}
+
+	return cc, nil
+}
+
+// Calendars return the calendar registry
+func (cc *CalendarConsumer) Calendars() *core.Calendars {
+	return cc.calendars
+}
+
+// WaitForDrain wait for consumer to EOF partitions
+func (cc *CalendarConsumer) WaitForDrain() {
+	cc.drainWg.Wait()
+}
+
+// Close the calendar consumer
+func (cc *CalendarConsumer) Close() (err error) {
+	for _, pc := range cc.partitions {
+		if e := pc.Close(); e != nil {
+			err = e
+		}
+	}
+	if e := cc.consumer.Close(); e != nil {
+		err = e
+	}
+	if e := cc.client.Close(); e != nil {
+		err = e
+	}
+	return
+}
+
+// Handle incomming messages
+func (cc *CalendarConsumer) handleMsg(msg *sarama.ConsumerMessage) error {
+	var c models.Calendar
+	if err := c.FromKafka(msg); err != nil {
+		return err
+	}
+
+	if c.Removed {
+		log.Infof("DELETE calendar: %s", c.GUID)
+		cc.calendars.Delete(c.GUID)
+		return nil
+	}
+
+	log.Infof("UPSERT calendar: %s", c.GUID)
+	cc.calendars.Set(c)
+	return nil
+}
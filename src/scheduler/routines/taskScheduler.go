@@ -3,15 +3,18 @@ package routines
 import (
 	"container/ring"
 	"encoding/json"
+	"fmt"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	redisV5 "gopkg.in/redis.v5"
 
 	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/quota"
 	"github.com/ovh/metronome/src/metronome/redis"
 	"github.com/ovh/metronome/src/scheduler/core"
 )
@@ -22,6 +25,9 @@ type batch struct {
 	jobs map[string][]models.Job
 }
 
+// quotaRefresh is the period of the tasks quota refresh, well below redis.QuotaTaskTTL.
+const quotaRefresh = time.Minute
+
 type state struct {
 	At      int64           `json:"at"`
 	Indexes map[int32]int64 `json:"indexes"`
@@ -30,6 +36,9 @@ type state struct {
 // TaskScheduler handle the internal states of the scheduler
 type TaskScheduler struct {
 	entries      map[string]*core.Entry
+	queue        *core.Queue
+	calendars    *core.Calendars
+	started      bool
 	nextExec     *ring.Ring
 	plan         *ring.Ring
 	now          time.Time
@@ -40,26 +49,37 @@ type TaskScheduler struct {
 	nextTimer    *time.Timer
 	jobProducer  *JobProducer
 	partition    int32
+	quota        quota.Quota
+	refreshedAt  time.Time
 	alive        sync.WaitGroup
 	entriesMutex sync.Mutex
 	// metrics
-	taskGauge   prometheus.Gauge
-	planCounter prometheus.Counter
+	taskGauge       prometheus.Gauge
+	planCounter     prometheus.Counter
+	throttleCounter prometheus.Counter
 }
 
-// NewTaskScheduler return a new task scheduler
-func NewTaskScheduler(partition int32, tasks <-chan models.Task) (*TaskScheduler, error) {
-	const buffSize = 20
+// NewTaskScheduler return a new task scheduler.
+// The planning horizon, in one second batches, is read from scheduler.horizon.
+// Tasks exceeding the users quotas are not scheduled, executions exceeding them are excluded.
+func NewTaskScheduler(partition int32, tasks <-chan models.Task, calendars *core.Calendars) (*TaskScheduler, error) {
+	horizon := viper.GetInt("scheduler.horizon")
+	if horizon < 2 {
+		return nil, fmt.Errorf("Bad scheduler horizon %d, must be at least 2", horizon)
+	}
 
 	ts := &TaskScheduler{
-		plan:      ring.New(buffSize),
+		plan:      ring.New(horizon),
 		entries:   make(map[string]*core.Entry),
+		queue:     core.NewQueue(),
+		calendars: calendars,
 		now:       time.Now().UTC(),
-		jobs:      make(chan []models.Job, buffSize),
+		jobs:      make(chan []models.Job, horizon),
 		halt:      make(chan struct{}),
 		planning:  make(chan struct{}, 1),
 		dispatch:  make(chan struct{}, 1),
 		partition: partition,
+		quota:     quota.Get(),
 	}
 	ts.plan.Value = batch{
 		ts.now,
@@ -85,6 +105,14 @@ func NewTaskScheduler(partition int32, tasks <-chan models.Task) (*TaskScheduler
 		ConstLabels: prometheus.Labels{"partition": strconv.Itoa(int(ts.partition))},
 	})
 	prometheus.MustRegister(ts.planCounter)
+	ts.throttleCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   "metronome",
+		Subsystem:   "scheduler",
+		Name:        "throttle",
+		Help:        "Number of executions excluded by the users quota.",
+		ConstLabels: prometheus.Labels{"partition": strconv.Itoa(int(ts.partition))},
+	})
+	prometheus.MustRegister(ts.throttleCounter)
 
 	// jobs producer
 	jobProducer, err := NewJobProducer(ts.jobs)
@@ -148,6 +176,7 @@ func (ts *TaskScheduler) Start() error {
 	ts.entriesMutex.Lock()
 
 	defer func() {
+		ts.started = true
 		ts.entriesMutex.Unlock()
 
 		ts.planning <- struct{}{}
@@ -199,17 +228,146 @@ func (ts *TaskScheduler) Start() error {
 	}
 
 	// Plan
+	ts.queue = core.NewQueue()
 	for guid, e := range ts.entries {
 		jobs, err := planEntryInBatch(e, ts.nextExec.Value.(batch).at)
 		if err != nil {
 			return err
 		}
 		ts.nextExec.Value.(batch).jobs[guid] = jobs
+		if e.Done() {
+			ts.drop(guid)
+			continue
+		}
+		ts.queue.Add(e)
+	}
+
+	return nil
+}
+
+// drop an exhausted entry, its planned jobs are kept.
+func (ts *TaskScheduler) drop(guid string) {
+	log.Infof("DONE task: %s", guid)
+	ts.release(guid)
+	ts.taskGauge.Dec()
+	delete(ts.entries, guid)
+	ts.queue.Remove(guid)
+}
+
+// remove an entry and clear its planned jobs.
+func (ts *TaskScheduler) remove(guid string) {
+	ts.release(guid)
+	ts.taskGauge.Dec()
+	delete(ts.entries, guid)
+	ts.queue.Remove(guid)
+	c := ts.nextExec
+	for i := 0; i < c.Len(); i++ {
+		if c.Value != nil {
+			// Clear schedule execution
+			c.Value.(batch).jobs[guid] = make([]models.Job, 0)
+		}
+
+		c = c.Next()
+	}
+}
+
+// acquire account a task in its user quota.
+// Return an error if the task exceed the quota.
+func (ts *TaskScheduler) acquire(t models.Task) error {
+	if err := ts.quota.Check(&t); err != nil {
+		return err
+	}
+
+	if ts.quota.Tasks <= 0 {
+		return nil
 	}
 
+	acquired, err := redis.DB().AcquireTask(t.UserID, t.GUID, ts.quota.Tasks)
+	if err != nil {
+		// Do not stop scheduling on quota accounting failure
+		log.WithError(err).Error("Cannot acquire task quota")
+		return nil
+	}
+
+	if !acquired {
+		return &quota.Exceeded{
+			Field:       "id",
+			Description: fmt.Sprintf("a user can not own more than %d tasks", ts.quota.Tasks),
+		}
+	}
 	return nil
 }
 
+// refresh keep the entries accounted in their user quota, every quotaRefresh.
+func (ts *TaskScheduler) refresh() {
+	if ts.quota.Tasks <= 0 || time.Since(ts.refreshedAt) < quotaRefresh {
+		return
+	}
+	ts.refreshedAt = time.Now()
+
+	guids := make(map[string][]string)
+	for guid, e := range ts.entries {
+		guids[e.UserID()] = append(guids[e.UserID()], guid)
+	}
+
+	for userID, g := range guids {
+		if err := redis.DB().RefreshTasks(userID, g); err != nil {
+			log.WithError(err).Error("Cannot refresh task quota")
+		}
+	}
+}
+
+// release remove a task from its user quota.
+func (ts *TaskScheduler) release(guid string) {
+	if ts.quota.Tasks <= 0 || ts.entries[guid] == nil {
+		return
+	}
+
+	if err := redis.DB().ReleaseTask(ts.entries[guid].UserID(), guid); err != nil {
+		log.WithError(err).Error("Cannot release task quota")
+	}
+}
+
+// throttle exclude the jobs exceeding the users executions quota.
+// Excluded jobs are still dispatched, so their states are reported.
+func (ts *TaskScheduler) throttle(at time.Time, jobs []models.Job) {
+	if ts.quota.Executions <= 0 {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, j := range jobs {
+		if !j.Excluded {
+			counts[j.UserID]++
+		}
+	}
+
+	allowed := make(map[string]int)
+	for userID, count := range counts {
+		total, err := redis.DB().Executions(userID, at, count)
+		if err != nil {
+			log.WithError(err).Error("Cannot account executions quota")
+			allowed[userID] = count
+			continue
+		}
+		allowed[userID] = ts.quota.Executions - int(total) + count
+	}
+
+	for i := range jobs {
+		if jobs[i].Excluded {
+			continue
+		}
+		if allowed[jobs[i].UserID] > 0 {
+			allowed[jobs[i].UserID]--
+			continue
+		}
+
+		log.Warnf("THROTTLE job: %s %d", jobs[i].GUID, jobs[i].At)
+		jobs[i].Excluded = true
+		ts.throttleCounter.Inc()
+	}
+}
+
 // stop the scheduler
 func (ts *TaskScheduler) stop() {
 	close(ts.dispatch)
@@ -237,32 +395,40 @@ func (ts *TaskScheduler) Jobs() <-chan []models.Job {
 
 // Handle incomming task
 func (ts *TaskScheduler) handleTask(t models.Task) error {
-	if t.Schedule == "" {
+	// Unscheduled tasks are run by the aggregator after their upstream tasks
+	if t.Deleted() || !t.Scheduled() {
 		if ts.entries[t.GUID] == nil {
 			return nil
 		}
 
 		log.Infof("DELETE task: %s", t.GUID)
-		ts.taskGauge.Dec()
-		delete(ts.entries, t.GUID)
-		c := ts.nextExec
-		for i := 0; i < c.Len(); i++ {
-			if c.Value != nil {
-				// Clear schedule execution
-				c.Value.(batch).jobs[t.GUID] = make([]models.Job, 0)
-			}
+		ts.remove(t.GUID)
+		return nil
+	}
 
-			c = c.Next()
+	// Updates omitting the secrets keep the entry ones
+	if t.SealedSecrets == models.SecretsCleared {
+		t.SealedSecrets = ""
+	} else if len(t.SealedSecrets) == 0 && ts.entries[t.GUID] != nil {
+		t.SealedSecrets = ts.entries[t.GUID].Secrets()
+	}
+
+	// Tasks bypassing the api quotas are not scheduled
+	if err := ts.acquire(t); err != nil {
+		log.WithError(err).Warnf("QUOTA task: %s", t.GUID)
+		if ts.entries[t.GUID] != nil {
+			ts.remove(t.GUID)
 		}
-		return nil
+		return err
 	}
 
 	taskUpdate := false
 	if ts.entries[t.GUID] != nil {
 		taskUpdate = true
 
-		// Update Task payload
+		// Update Task payload and secrets
 		ts.entries[t.GUID].SetPayload(t.Payload)
+		ts.entries[t.GUID].SetSecrets(t.SealedSecrets)
 
 		if ts.entries[t.GUID].SameAs(t) {
 			log.Infof("NOP task: %s", t.GUID)
@@ -292,6 +458,7 @@ func (ts *TaskScheduler) handleTask(t models.Task) error {
 		log.WithError(err).Errorf("unprocessable task(%+v)", t)
 		return err
 	}
+	e.UseCalendars(ts.calendars)
 	ts.entries[t.GUID] = e
 
 	// Plan executions
@@ -312,6 +479,13 @@ func (ts *TaskScheduler) handleTask(t models.Task) error {
 		c = c.Next()
 	}
 
+	// Entries are re-initialized on start, keep them until then
+	if ts.started && e.Done() {
+		ts.drop(t.GUID)
+		return nil
+	}
+	ts.queue.Add(e)
+
 	return nil
 }
 
@@ -327,6 +501,7 @@ func (ts *TaskScheduler) handleDispatch() {
 			send += len(js)
 			jobs = append(jobs, js...)
 		}
+		ts.throttle(ts.nextExec.Value.(batch).at, jobs)
 		ts.jobs <- jobs
 		ts.nextExec.Value = nil
 		ts.nextExec = ts.nextExec.Next()
@@ -370,7 +545,8 @@ func (ts *TaskScheduler) handleDispatch() {
 	}
 }
 
-// Plan next executions
+// Plan next executions.
+// Only the entries due in the new batch are planned.
 func (ts *TaskScheduler) handlePlanning() error {
 	if ts.plan.Next().Value != nil {
 		return nil
@@ -387,17 +563,29 @@ func (ts *TaskScheduler) handlePlanning() error {
 		make(map[string][]models.Job),
 	}
 
-	for k := range ts.entries {
-		jobs, err := planEntryInBatch(ts.entries[k], ts.now)
+	var due []*core.Entry
+	for e := ts.queue.PopDue(ts.now.Unix()); e != nil; e = ts.queue.PopDue(ts.now.Unix()) {
+		due = append(due, e)
+	}
+
+	for _, e := range due {
+		jobs, err := planEntryInBatch(e, ts.now)
 		if err != nil {
 			return err
 		}
 
 		if len(jobs) > 0 {
-			ts.plan.Value.(batch).jobs[k] = jobs
+			ts.plan.Value.(batch).jobs[e.GUID()] = jobs
 		}
+		if e.Done() {
+			ts.drop(e.GUID())
+			continue
+		}
+		ts.queue.Add(e)
 	}
 
+	ts.refresh()
+
 	next := ts.plan.Next()
 	// Plan next batch if available
 	if next.Value == nil {
@@ -418,7 +606,7 @@ func planEntryInBatch(entry *core.Entry, at time.Time) ([]models.Job, error) {
 	}
 
 	for entry.Next() > 0 && entry.Next() <= at.Unix() {
-		jobs = append(jobs, models.Job{GUID: entry.GUID(), UserID: entry.UserID(), At: entry.Next(), Epsilon: entry.Epsilon(), URN: entry.URN(), Payload: entry.GetPayload()})
+		jobs = append(jobs, models.Job{GUID: entry.GUID(), UserID: entry.UserID(), At: entry.Next(), Epsilon: entry.Epsilon(), URN: entry.URN(), Payload: entry.GetPayload(), Excluded: entry.Excluded(), Last: entry.Last(), TaskID: entry.ID(), Async: entry.Async(), Deadline: entry.Deadline(), Concurrency: entry.Concurrency(), Secrets: entry.Secrets(), Capture: entry.Capture(), Template: entry.Template(), ProjectID: entry.ProjectID()})
 		plan, err := entry.Plan(at)
 		if err != nil {
 			return nil, err
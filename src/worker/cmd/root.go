@@ -9,6 +9,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/ovh/metronome/src/metronome/metrics"
+	"github.com/ovh/metronome/src/metronome/secrets"
 	"github.com/ovh/metronome/src/worker/consumers"
 )
 
@@ -20,6 +21,7 @@ func init() {
 	RootCmd.PersistentFlags().BoolP("verbose", "v", false, "verbose output")
 
 	RootCmd.Flags().StringSlice("kafka.brokers", []string{"localhost:9092"}, "kafka brokers address")
+	RootCmd.Flags().String("redis.addr", "127.0.0.1:6379", "redis address")
 	RootCmd.Flags().String("metrics.addr", "127.0.0.1:9100", "metrics address")
 
 	if err := viper.BindPFlags(RootCmd.PersistentFlags()); err != nil {
@@ -45,10 +47,14 @@ func initConfig() {
 	viper.SetDefault("kafka.topics.tasks", "tasks")
 	viper.SetDefault("kafka.topics.jobs", "jobs")
 	viper.SetDefault("kafka.topics.states", "states")
+	viper.SetDefault("kafka.topics.calendars", "calendars")
+	viper.SetDefault("kafka.topics.audit", "audit")
 	viper.SetDefault("kafka.groups.schedulers", "schedulers")
 	viper.SetDefault("kafka.groups.aggregators", "aggregators")
 	viper.SetDefault("kafka.groups.workers", "workers")
 	viper.SetDefault("worker.poolsize", 100)
+	viper.SetDefault("worker.response.limit", 65536)
+	viper.SetDefault("worker.lock.ttl", 3600)
 	viper.SetDefault("token.ttl", 3600)
 	viper.SetDefault("redis.pass", "")
 
@@ -90,6 +96,9 @@ func initConfig() {
 			log.Panicf("Fatal error in config file: %v \n", err)
 		}
 	}
+	if _, err := secrets.Keys(); err != nil && err != secrets.ErrDisabled {
+		log.WithError(err).Panic("Bad secrets keys")
+	}
 }
 
 // RootCmd launch the worker agent.
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,19 +21,34 @@ import (
 
 	"github.com/ovh/metronome/src/metronome/kafka"
 	"github.com/ovh/metronome/src/metronome/models"
+	"github.com/ovh/metronome/src/metronome/redis"
+	"github.com/ovh/metronome/src/metronome/secrets"
+	"github.com/ovh/metronome/src/metronome/templates"
 )
 
+// pullScheme is the URN scheme of jobs held in a pull queue.
+const pullScheme = "pull://"
+
+// lockRetry is the delay of queued jobs waiting for the task lock.
+const lockRetry = time.Second
+
 // JobConsumer consumed jobs messages from a Kafka topic and send them as HTTP POST request.
 type JobConsumer struct {
 	consumer *saramaC.Consumer
 	producer sarama.SyncProducer
 	wg       *sync.WaitGroup // Used to sync shut down
+	halt     chan struct{}
 	// metrics
 	jobCounter        *prometheus.CounterVec
 	jobTime           *prometheus.HistogramVec
 	jobSuccessCounter *prometheus.CounterVec
 	jobFailureCounter *prometheus.CounterVec
 	jobExpireCounter  *prometheus.CounterVec
+	jobExcludeCounter *prometheus.CounterVec
+	jobPullCounter    *prometheus.CounterVec
+	jobRunningCounter *prometheus.CounterVec
+	jobSkipCounter    *prometheus.CounterVec
+	jobDelayCounter   *prometheus.CounterVec
 	httpClient        *http.Client
 }
 
@@ -123,6 +139,46 @@ func NewJobConsumer() (*JobConsumer, error) {
 	},
 		[]string{"partition"})
 	prometheus.MustRegister(jc.jobExpireCounter)
+	jc.jobExcludeCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metronome",
+		Subsystem: "worker",
+		Name:      "jobs_exclude",
+		Help:      "Number of jobs excluded by a calendar.",
+	},
+		[]string{"partition"})
+	prometheus.MustRegister(jc.jobExcludeCounter)
+	jc.jobPullCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metronome",
+		Subsystem: "worker",
+		Name:      "jobs_pull",
+		Help:      "Number of jobs enqueued for pull.",
+	},
+		[]string{"partition"})
+	prometheus.MustRegister(jc.jobPullCounter)
+	jc.jobRunningCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metronome",
+		Subsystem: "worker",
+		Name:      "jobs_running",
+		Help:      "Number of async jobs accepted.",
+	},
+		[]string{"partition"})
+	prometheus.MustRegister(jc.jobRunningCounter)
+	jc.jobSkipCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metronome",
+		Subsystem: "worker",
+		Name:      "jobs_skip",
+		Help:      "Number of jobs skipped by the concurrency policy.",
+	},
+		[]string{"partition"})
+	prometheus.MustRegister(jc.jobSkipCounter)
+	jc.jobDelayCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metronome",
+		Subsystem: "worker",
+		Name:      "jobs_delay",
+		Help:      "Number of jobs delayed by the queue concurrency policy.",
+	},
+		[]string{"partition"})
+	prometheus.MustRegister(jc.jobDelayCounter)
 
 	// Spawning workers
 	poolSize := viper.GetInt("worker.poolsize")
@@ -132,16 +188,47 @@ func NewJobConsumer() (*JobConsumer, error) {
 		jc.wg.Add(1)
 		go jc.Worker(i + 1)
 	}
+
+	// Retry delayed jobs
+	jc.halt = make(chan struct{})
+	go jc.retry()
+
 	return jc, nil
 }
 
 // Close the consumer.
 func (jc *JobConsumer) Close() error {
+	close(jc.halt)
 	err := jc.consumer.Close()
 	jc.wg.Wait() // wait for all workers to shut down properly
 	return err
 }
 
+// retry produce back the delayed jobs once due.
+func (jc *JobConsumer) retry() {
+	ticker := time.NewTicker(lockRetry)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			jobs, err := redis.DB().Due(time.Now())
+			if err != nil {
+				log.WithError(err).Warn("Could not get the delayed jobs")
+				continue
+			}
+
+			for _, j := range jobs {
+				if _, _, err := jc.producer.SendMessage(j.ToKafka()); err != nil {
+					log.WithError(err).Error("Could not retry the delayed job")
+				}
+			}
+		case <-jc.halt:
+			return
+		}
+	}
+}
+
 // Worker is the main goroutine that is calling handleMsg
 func (jc *JobConsumer) Worker(id int) {
 	defer jc.wg.Done()
@@ -181,20 +268,58 @@ func (jc *JobConsumer) handleMsg(msg *sarama.ConsumerMessage) error {
 	}).Debug("POST")
 
 	s := models.State{
-		ID:       "",
-		TaskGUID: j.GUID,
-		UserID:   j.UserID,
-		At:       j.At,
-		DoneAt:   start.Unix(),
-		Duration: time.Since(start).Nanoseconds() / 1000,
-		URN:      j.URN,
-		State:    models.Success,
+		ID:        j.ID(),
+		TaskGUID:  j.GUID,
+		UserID:    j.UserID,
+		At:        j.At,
+		DoneAt:    start.Unix(),
+		Duration:  time.Since(start).Nanoseconds() / 1000,
+		URN:       j.URN,
+		State:     models.Success,
+		Last:      j.Last,
+		ProjectID: j.ProjectID,
 	}
 
-	if j.At < start.Unix()-j.Epsilon {
+	// Rendered secrets must not be kept at rest
+	unrendered := j
+
+	if j.Excluded {
+		s.State = models.Excluded
+	} else if j.At < start.Unix()-j.Epsilon {
 		s.State = models.Expired
+	} else if strings.HasPrefix(j.URN, pullScheme) && len(j.Secrets) > 0 {
+		// Queued jobs are stored rendered, secrets must not be queued
+		log.Warn("Secrets are not supported by pull jobs")
+		s.State = models.Failed
+	} else if err := render(&j, start); err != nil {
+		log.WithError(err).Warn("Cannot render job templates")
+		s.State = models.Failed
+	} else if locked, err := jc.lock(j); err != nil {
+		log.WithError(err).Warn("Could not lock the task")
+		s.State = models.Failed
+	} else if !locked && j.Concurrency == models.ConcurrencyQueue {
+		// Retried until the lock is released, or the job expire past its epsilon
+		if err := redis.DB().Delay(unrendered, start.Add(lockRetry)); err != nil {
+			log.WithError(err).Warn("Could not delay the job")
+			s.State = models.Failed
+		} else {
+			jc.jobDelayCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
+			return nil
+		}
+	} else if !locked {
+		s.State = models.Skipped
+	} else if strings.HasPrefix(j.URN, pullScheme) {
+		// The state is produced by the API once the job is acked or nacked
+		if err := redis.DB().Enqueue(strings.TrimPrefix(j.URN, pullScheme), j); err != nil {
+			log.WithError(err).Warn("Could not enqueue the job")
+			s.State = models.Failed
+			unlock(j)
+		} else {
+			jc.jobPullCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
+			return nil
+		}
 	} else {
-		url, err := url.Parse(s.URN)
+		url, err := url.Parse(j.URN)
 		if err != nil {
 			s.State = models.Failed
 		} else {
@@ -202,18 +327,24 @@ func (jc *JobConsumer) handleMsg(msg *sarama.ConsumerMessage) error {
 			q.Set("time", strconv.FormatInt(j.At, 10))
 			q.Set("epsilon", strconv.FormatInt(j.Epsilon, 10))
 			q.Set("at", strconv.FormatInt(time.Now().Unix(), 10))
+			q.Set("id", j.ID())
 			url.RawQuery = q.Encode()
 
 			body, err := json.Marshal(j.Payload)
 			if err != nil {
 				log.WithError(err).Warn("Cannot marshall payload")
 				s.State = models.Failed
+			} else if err := jc.run(j, unrendered, s); err != nil {
+				log.WithError(err).Warn("Could not register the running job")
+				s.State = models.Failed
 			} else {
 				res, err := jc.httpClient.Post(url.String(), "application/json", bytes.NewReader(body))
 				if err != nil {
 					log.WithError(err).Warn("Could not post form")
 				} else {
-					if _, err = io.Copy(ioutil.Discard, res.Body); err != nil {
+					if j.Capture {
+						s.Response = readResponse(res)
+					} else if _, err := io.Copy(ioutil.Discard, res.Body); err != nil {
 						log.WithError(err).Warn("Failed to discard response body")
 					}
 					if err = res.Body.Close(); err != nil {
@@ -223,12 +354,31 @@ func (jc *JobConsumer) handleMsg(msg *sarama.ConsumerMessage) error {
 
 				if err != nil || res.StatusCode < 200 || res.StatusCode >= 300 {
 					s.State = models.Failed
+					if j.Async {
+						// The target may have completed the job meanwhile
+						rj, err := redis.DB().Complete(j.Owner(), j.ID())
+						if err != nil {
+							log.WithError(err).Warn("Could not unregister the running job")
+						} else if rj == nil {
+							return nil
+						}
+					}
+				} else if j.Async {
+					// The target complete the job later on through the API,
+					// the running state is already produced
+					s.State = models.Running
 				}
 			}
 		}
+
+		// Running jobs release the lock on completion
+		if s.State != models.Running {
+			unlock(j)
+		}
 	}
 
 	jc.jobTime.WithLabelValues(strconv.Itoa(int(msg.Partition))).Observe(time.Since(start).Seconds()) // to seconds
+	running := s.State == models.Running
 
 	switch s.State {
 	case models.Success:
@@ -237,6 +387,16 @@ func (jc *JobConsumer) handleMsg(msg *sarama.ConsumerMessage) error {
 		jc.jobFailureCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
 	case models.Expired:
 		jc.jobExpireCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
+	case models.Excluded:
+		jc.jobExcludeCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
+	case models.Running:
+		jc.jobRunningCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
+	case models.Skipped:
+		jc.jobSkipCounter.WithLabelValues(strconv.Itoa(int(msg.Partition))).Inc()
+	}
+
+	if running {
+		return nil
 	}
 
 	if _, _, err := jc.producer.SendMessage(s.ToKafka()); err != nil {
@@ -245,3 +405,156 @@ func (jc *JobConsumer) handleMsg(msg *sarama.ConsumerMessage) error {
 	}
 	return nil
 }
+
+// run register an async job and produce its running state, before the job is posted
+// as the target may complete it during the request. Synchronous jobs are left as is.
+func (jc *JobConsumer) run(j, unrendered models.Job, s models.State) error {
+	if !j.Async {
+		return nil
+	}
+
+	rj := redis.RunningJob{ID: j.ID(), Job: j, StartedAt: s.DoneAt}
+	if len(j.Secrets) > 0 {
+		rj.Job = unrendered
+	}
+	if err := redis.DB().Run(rj); err != nil {
+		return err
+	}
+
+	s.State = models.Running
+	s.Last = false
+	if _, _, err := jc.producer.SendMessage(s.ToKafka()); err != nil {
+		if _, cErr := redis.DB().Complete(j.Owner(), j.ID()); cErr != nil {
+			log.WithError(cErr).Warn("Could not unregister the running job")
+		}
+		return err
+	}
+	return nil
+}
+
+// lock the job task according to its concurrency policy.
+// Return false if the job must be skipped, or delayed for the queue policy.
+func (jc *JobConsumer) lock(j models.Job) (bool, error) {
+	ttl := time.Duration(viper.GetInt("worker.lock.ttl")) * time.Second
+
+	switch j.Concurrency {
+	case models.ConcurrencyForbid, models.ConcurrencyQueue:
+		// the lock expire at worst after its ttl
+		return redis.DB().Lock(j.GUID, j.ID(), ttl)
+	case models.ConcurrencyReplace:
+		previous, err := redis.DB().ForceLock(j.GUID, j.ID(), ttl)
+		if err != nil || len(previous) == 0 || previous == j.ID() {
+			return err == nil, err
+		}
+
+		// Fail the replaced job if still running
+		rj, err := redis.DB().Complete(j.Owner(), previous)
+		if err != nil {
+			return false, err
+		}
+		if rj != nil {
+			now := time.Now()
+			s := models.State{
+				ID:        rj.ID,
+				TaskGUID:  rj.Job.GUID,
+				UserID:    rj.Job.UserID,
+				At:        rj.Job.At,
+				DoneAt:    now.Unix(),
+				Duration:  now.Sub(time.Unix(rj.StartedAt, 0)).Nanoseconds() / 1000,
+				URN:       rj.Job.URN,
+				State:     models.Failed,
+				Last:      rj.Job.Last,
+				ProjectID: rj.Job.ProjectID,
+			}
+			if _, _, err := jc.producer.SendMessage(s.ToKafka()); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	default:
+		return true, nil
+	}
+}
+
+// unlock the job task if locked by its concurrency policy.
+func unlock(j models.Job) {
+	if !j.Locked() {
+		return
+	}
+
+	if err := redis.DB().Unlock(j.GUID, j.ID()); err != nil {
+		log.WithError(err).Warn("Could not unlock the task")
+	}
+}
+
+// render the job URN and payload templates, if the task use templates.
+// The task secrets are opened for the templates only.
+func render(j *models.Job, now time.Time) error {
+	if !j.Template {
+		return nil
+	}
+
+	ctx := templates.Context{
+		TaskID:      j.TaskID,
+		ScheduledAt: time.Unix(j.At, 0).UTC(),
+		Now:         now.UTC(),
+		Attempt:     1,
+		Upstream:    j.Upstream,
+		Upstreams:   j.Upstreams,
+	}
+
+	if len(j.Secrets) > 0 {
+		keys, err := secrets.Keys()
+		if err != nil {
+			return err
+		}
+
+		ctx.Secrets, err = keys.Open(j.Secrets)
+		if err != nil {
+			return err
+		}
+	}
+
+	urn, err := templates.RenderString(j.URN, ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := templates.Render(j.Payload, ctx)
+	if err != nil {
+		return err
+	}
+
+	j.URN = urn
+	j.Payload = payload
+	return nil
+}
+
+// readResponse read the job endpoint response, for tasks capturing it.
+// Bodies larger than worker.response.limit bytes are discarded.
+func readResponse(res *http.Response) *models.Response {
+	r := &models.Response{
+		Status: res.StatusCode,
+	}
+
+	limit := viper.GetInt64("worker.response.limit")
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		log.WithError(err).Warn("Failed to read response body")
+		return r
+	}
+
+	if _, err = io.Copy(ioutil.Discard, res.Body); err != nil {
+		log.WithError(err).Warn("Failed to discard response body")
+	}
+
+	if int64(len(body)) > limit || len(body) == 0 {
+		return r
+	}
+
+	if err := json.Unmarshal(body, &r.Body); err != nil {
+		r.Body = string(body)
+	}
+
+	return r
+}